@@ -1,24 +1,601 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"io/fs"
+	"log"
+	"maps"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 	"unicode/utf8"
 
+	"csvtoxls/csvxls"
+
 	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
 )
 
+// Version of the tool, stamped by release builds with
+// -ldflags "-X main.version=1.2.0"
+var version = "dev"
+
+// Module path of excelize, whose version -version reports
+const excelizeModule = "github.com/xuri/excelize/v2"
+
+// Print the tool version and those of Go and excelize it was built with
+func printVersion() {
+	excelizeVersion := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == excelizeModule {
+				excelizeVersion = dep.Version
+				if dep.Replace != nil {
+					excelizeVersion = dep.Replace.Version
+				}
+			}
+		}
+	}
+	fmt.Printf("csvtoxls %s (%s, excelize %s, %s/%s)\n", version, runtime.Version(), excelizeVersion, runtime.GOOS, runtime.GOARCH)
+}
+
+// Conversion options shared by all processing modes: the conversion
+// settings of the csvxls package plus those of the command line modes
+type options struct {
+	csvxls.Options
+
+	outputPath  string            // Output file in single-file mode (empty to derive it from the input)
+	outputDir   string            // Output directory in directory mode (empty to write next to each CSV)
+	keepTree    bool              // Mirror the subdirectories of the scanned directory under outputDir
+	excludes    []string          // Base name patterns of CSV files skipped in directory mode
+	recursive   bool              // Also scan the subdirectories in directory mode
+	index       bool              // Add an index sheet linking to the data sheets in -s mode
+	active      string            // Sheet or source file of the sheet shown on opening in -s mode
+	sortSheets  string            // Order of the sheets in -s mode: none, name or path
+	workbook    string            // Existing workbook receiving the sheets instead of a new one (empty for a new one)
+	ext         string            // Extension of the new workbooks, with the dot (e.g. .xlsx)
+	qualify     bool              // Name the sheets after the relative path of the CSV in -s mode
+	colorByDir  bool              // Give the sheets of the CSVs of each folder the same tab color in -s mode
+	sheetMap    map[string]string // Sheet names by CSV base name or relative path in -s mode
+	listPath    string            // Manifest listing the CSV files of the run instead of scanning the directory
+	listFiles   []string          // CSV files listed by the manifest, in order
+	perFolder   bool              // Write one -s workbook per folder of the scanned tree
+	folderFiles []string          // CSV files of the folder combined by a -per-dir workbook (nil to scan the directory)
+	jsonOutput  bool              // Print a JSON report instead of the human-readable lines
+	failFast    bool              // Stop at the first file that fails to convert
+	progress    bool              // Report the progress of directory runs on standard error
+
+	incremental bool             // Skip the CSV files unchanged since their last conversion in directory mode
+	cache       *conversionCache // Conversions of the previous runs with -incremental (nil without it)
+	stdout      bool             // Write the workbook of the single-file mode to standard output
+	noClobber   bool             // Skip the conversions whose output file already exists
+	force       bool             // Let -reverse overwrite the existing CSV files
+}
+
+// Print an informational message, unless -q is set
+func (o options) infof(format string, args ...interface{}) {
+	if !o.Quiet {
+		fmt.Fprintf(o.MessageWriter(), format+"\n", args...)
+	}
+}
+
+// Report errExists when -no-clobber forbids overwriting the output file
+func (o options) checkClobber(xlsxFilePath string) error {
+	if !o.noClobber {
+		return nil
+	}
+	if _, err := os.Stat(xlsxFilePath); err == nil {
+		return fmt.Errorf("%w: %s", errExists, xlsxFilePath)
+	}
+	return nil
+}
+
+// Print the progress of a directory run after each file, with -progress;
+// it goes to standard error to keep the -json report clean
+func (o options) progressf(done, total int, path string) {
+	if o.progress {
+		fmt.Fprintf(os.Stderr, "[%d/%d] %3d%% %s\n", done, total, done*100/total, path)
+	}
+}
+
+// Print a line of the final summary, unless -json is set
+func (o options) summaryf(format string, args ...interface{}) {
+	if !o.jsonOutput {
+		fmt.Fprintf(o.MessageWriter(), format+"\n", args...)
+	}
+}
+
+// Flag value collecting patterns from repeated and comma-separated occurrences
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		*p = append(*p, pattern)
+	}
+	return nil
+}
+
+// Error returned by the modes converting several files when some of them failed
+type batchError struct {
+	failed int
+	total  int
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("%d of %d files failed", e.failed, e.total)
+}
+
+// Error returned for a file skipped by -no-clobber because its output file
+// already exists
+var errExists = errors.New("output file already exists")
+
+// Error returned by the modes converting several files when -fail-fast
+// stopped them at the first failure
+var errFailFast = errors.New("run aborted at the first failure (-fail-fast)")
+
+// Totals collected over the whole run for -metricsfile and -json
+type runMetrics struct {
+	start          time.Time
+	fileStart      time.Time // Start of the file being converted, set by begin
+	filesConverted int
+	filesFailed    int
+	filesExcluded  int
+	filesSkipped   int
+	filesUnchanged int
+	csvxls.Stats
+	results []fileResult
+}
+
+var metrics = runMetrics{start: time.Now()}
+
+// Logger of -log, writing timestamped entries to the log file (nil without -log)
+var runLog *log.Logger
+
+// Close the log file of runLog
+var closeRunLog func() error
+
+// Open the -log file, creating it if missing. It is not buffered: each
+// entry is appended by a single write as soon as it is logged, so the log
+// is complete while -watch runs and after a crash or a kill
+func openRunLog(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// Write the outcome of one file to the -log file, with the time spent on it
+func logResult(result fileResult, err error, elapsed time.Duration) {
+	if runLog == nil {
+		return
+	}
+
+	target := result.Output
+	if result.Sheet != "" {
+		target += " [" + result.Sheet + "]"
+	}
+	elapsed = elapsed.Round(time.Millisecond)
+	switch {
+	case errors.Is(err, csvxls.ErrNoData):
+		runLog.Printf("skipped %s: it has no data rows (%s)", result.Source, elapsed)
+	case errors.Is(err, errUnchanged):
+		runLog.Printf("skipped %s: unchanged since its conversion to %s", result.Source, target)
+	case errors.Is(err, errExists):
+		runLog.Printf("skipped %s: %v", result.Source, err)
+	case err != nil && target == "":
+		runLog.Printf("failed %s after %s: %v", result.Source, elapsed, err)
+	case err != nil:
+		runLog.Printf("failed %s -> %s after %s: %v", result.Source, target, elapsed, err)
+	default:
+		runLog.Printf("converted %s -> %s: %d rows, %d columns in %s", result.Source, target, result.Rows, result.Columns, elapsed)
+	}
+}
+
+// Outcome of the conversion of one CSV file, as reported by -json
+type fileResult struct {
+	Source    string `json:"source"`
+	Output    string `json:"output,omitempty"`
+	Sheet     string `json:"sheet,omitempty"`
+	Rows      int    `json:"rows"`
+	Columns   int    `json:"columns"`
+	OK        bool   `json:"ok"`
+	Skipped   bool   `json:"skipped,omitempty"`
+	Unchanged bool   `json:"unchanged,omitempty"`
+	Exists    bool   `json:"exists,omitempty"`
+	Long      int    `json:"long_values,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Start timing the conversion of the next file recorded
+func (m *runMetrics) begin() {
+	m.fileStart = time.Now()
+}
+
+// Record the conversion of one file, with the stats collected while
+// converting it, in the run totals
+func (m *runMetrics) record(source, output, sheet string, stats csvxls.Stats, err error) {
+	result := fileResult{
+		Source:  source,
+		Output:  output,
+		Sheet:   sheet,
+		Rows:    stats.Rows,
+		Columns: stats.Columns,
+		Long:    stats.Long,
+		OK:      err == nil,
+	}
+
+	// Time spent on the file since its begin, not counting the time spent
+	// between files, such as the polling of -watch
+	var elapsed time.Duration
+	if !m.fileStart.IsZero() {
+		elapsed = time.Since(m.fileStart)
+		m.fileStart = time.Time{}
+	}
+	logResult(result, err, elapsed)
+
+	// Files without data rows skipped by -skip-empty-files wrote nothing
+	if errors.Is(err, csvxls.ErrNoData) {
+		result.Skipped = true
+		result.Rows = 0
+		m.filesSkipped++
+		m.results = append(m.results, result)
+		m.Bytes += stats.Bytes
+		return
+	}
+
+	// Files skipped by -no-clobber were not read
+	if errors.Is(err, errExists) {
+		result.Skipped = true
+		result.Exists = true
+		m.filesSkipped++
+		m.results = append(m.results, result)
+		return
+	}
+
+	// Files skipped by -incremental were not read
+	if errors.Is(err, errUnchanged) {
+		result.Unchanged = true
+		m.filesUnchanged++
+		m.results = append(m.results, result)
+		return
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		m.filesFailed++
+	} else {
+		m.filesConverted++
+	}
+	m.results = append(m.results, result)
+
+	m.Rows += stats.Rows
+	m.Columns = max(m.Columns, stats.Columns)
+	m.Bytes += stats.Bytes
+}
+
+// Return the rows written by the files converted successfully
+func (m *runMetrics) convertedRows() int {
+	rows := 0
+	for _, result := range m.results {
+		if result.OK {
+			rows += result.Rows
+		}
+	}
+	return rows
+}
+
+// Return the part of a summary counting the files skipped by
+// -skip-empty-files, empty when there were none
+func skippedSummary(count int) string {
+	if count == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %d empty skipped", count)
+}
+
+// Return the part of a summary counting the files skipped by -no-clobber,
+// empty when there were none
+func existingSummary(count int) string {
+	if count == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %d existing skipped", count)
+}
+
+// Return the part of the summary of a file counting the values over
+// -max-cell-len, empty when there were none
+func longSummary(stats csvxls.Stats, opts options) string {
+	switch {
+	case stats.Long == 0:
+		return ""
+	case opts.SplitLongCells:
+		return fmt.Sprintf(", %d long values split", stats.Long)
+	}
+	return fmt.Sprintf(", %d long values truncated", stats.Long)
+}
+
+// Return the part of a summary counting the files skipped by -incremental,
+// empty when there were none
+func unchangedSummary(count int) string {
+	if count == 0 {
+		return ""
+	}
+	return fmt.Sprintf(", %d unchanged skipped", count)
+}
+
+// Name of the -incremental cache file, in the scanned directory
+const cacheFileName = ".csvtoxls-cache.json"
+
+// Error returned for a file skipped by -incremental because it did not
+// change since its last conversion
+var errUnchanged = errors.New("the file did not change since its last conversion")
+
+// Size and modification time of a CSV file when it was last converted,
+// and the absolute path of the workbook it was converted to
+type cacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Output  string    `json:"output"`
+}
+
+// Conversions of the previous runs with -incremental, by absolute CSV path
+type conversionCache struct {
+	path  string
+	Files map[string]cacheEntry `json:"files"`
+}
+
+// Load the -incremental cache of the scanned directory, starting an empty
+// one when there is none or it cannot be read
+func loadConversionCache(dirPath string) *conversionCache {
+	cache := &conversionCache{path: filepath.Join(dirPath, cacheFileName)}
+	data, err := os.ReadFile(cache.path)
+	if err == nil {
+		err = json.Unmarshal(data, cache)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring the cache %s, every file is converted: %v\n", cache.path, err)
+		cache.Files = nil
+	}
+	if cache.Files == nil {
+		cache.Files = make(map[string]cacheEntry)
+	}
+	return cache
+}
+
+// Write the cache back, warning when it cannot be saved
+func (c *conversionCache) save() {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err == nil {
+		err = csvxls.WriteFileAtomically(c.path, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: unable to save the cache %s: %v\n", c.path, err)
+	}
+}
+
+// Return the absolute form of a path, the path itself when it has none
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// Report whether a CSV file has the size and modification time recorded
+// when it was converted to output, and output still exists and is not
+// older than the CSV
+func (c *conversionCache) unchanged(csvFilePath, output string) bool {
+	entry, ok := c.Files[absPath(csvFilePath)]
+	if !ok || entry.Output != absPath(output) {
+		return false
+	}
+	info, err := os.Stat(csvFilePath)
+	if err != nil || info.Size() != entry.Size || !info.ModTime().Equal(entry.ModTime) {
+		return false
+	}
+	outputInfo, err := os.Stat(output)
+	return err == nil && !outputInfo.ModTime().Before(info.ModTime())
+}
+
+// Report whether all the CSV files combined into output are unchanged and
+// are the same files as in its last conversion
+func (c *conversionCache) unchangedAll(csvFiles []string, output string) bool {
+	converted := 0
+	for _, entry := range c.Files {
+		if entry.Output == absPath(output) {
+			converted++
+		}
+	}
+	return converted == len(csvFiles) && !slices.ContainsFunc(csvFiles, func(csvFilePath string) bool {
+		return !c.unchanged(csvFilePath, output)
+	})
+}
+
+// Record the conversion of a CSV file to output
+func (c *conversionCache) update(csvFilePath, output string) {
+	info, err := os.Stat(csvFilePath)
+	if err != nil {
+		c.forget(csvFilePath)
+		return
+	}
+	c.Files[absPath(csvFilePath)] = cacheEntry{Size: info.Size(), ModTime: info.ModTime(), Output: absPath(output)}
+}
+
+// Record that the CSV files combined into output are exactly csvFiles
+func (c *conversionCache) updateAll(csvFiles []string, output string) {
+	maps.DeleteFunc(c.Files, func(_ string, entry cacheEntry) bool {
+		return entry.Output == absPath(output)
+	})
+	for _, csvFilePath := range csvFiles {
+		c.update(csvFilePath, output)
+	}
+}
+
+// Drop the record of a CSV file, so that it is converted again next time
+func (c *conversionCache) forget(csvFilePath string) {
+	delete(c.Files, absPath(csvFilePath))
+}
+
+// Skip the conversion of all the CSV files combined into output when
+// -incremental finds them unchanged, reporting whether they were skipped
+func skipUnchangedAll(csvFiles []string, output string, opts options) bool {
+	if opts.cache == nil || !opts.cache.unchangedAll(csvFiles, output) {
+		return false
+	}
+	for _, csvFilePath := range csvFiles {
+		metrics.record(csvFilePath, output, "", csvxls.Stats{}, errUnchanged)
+	}
+	opts.summaryf("\nSummary: %s is up to date, %d unchanged skipped", output, len(csvFiles))
+	return true
+}
+
+// Environment variable holding the workbook password, preferred to -password
+// because it does not end up in the shell history
+const passwordEnvVar = "CSVTOXLS_PASSWORD"
+
+// Colors accepted on the command line
+var colorPattern = regexp.MustCompile(`^#[0-9A-F]{6}$`)
+
 func main() {
 	// Define flags
 	fileFlag := flag.String("f", "", "Path to a single CSV file to convert")
+	titleFlag := flag.String("title", "", "Title document property of the workbook (default: the name of the CSV, directory or archive)")
+	authorFlag := flag.String("author", "", "Author document property of the workbook")
+	companyFlag := flag.String("company", "", "Company document property of the workbook")
+	passwordFlag := flag.String("password", "", "Encrypt the XLSX output with this password (the "+passwordEnvVar+" environment variable takes precedence)")
+	sheetFlag := flag.String("sheet", "", "Sheet name in single-file mode (default: derived from the file name)")
+	outputFlag := flag.String("o", "", "Output XLSX path in single-file mode (default: next to the source file)")
+	stdoutFlag := flag.Bool("stdout", false, "Write the XLSX workbook of -f to standard output instead of a file; messages go to standard error")
 	dirFlag := flag.String("d", "", "Path to a directory containing CSV files to convert")
+	listFlag := flag.String("list", "", "Manifest listing the CSV files to convert, one path per line, instead of -d")
+	outDirFlag := flag.String("outdir", "", "In directory mode, write the XLSX files into this directory (created if missing)")
+	var recursiveFlag bool
+	flag.BoolVar(&recursiveFlag, "r", false, "In directory mode, also convert CSV files in subdirectories")
+	flag.BoolVar(&recursiveFlag, "recursive", false, "Same as -r")
+	var excludeFlag patternList
+	flag.Var(&excludeFlag, "exclude", "In directory mode, skip CSV files whose name matches this pattern (e.g. *_bak.csv); repeatable or comma-separated")
+	keepTreeFlag := flag.Bool("keep-tree", false, "With -outdir, preserve the subdirectory structure of the scanned directory")
+	indexFlag := flag.Bool("index", false, "With -s, add a first sheet named Index linking to every data sheet")
+	namesFlag := flag.String("names", "", "With -s, read sheet names from this CSV file of sourcefile,sheetname rows")
+	extFlag := flag.String("ext", "xlsx", "Extension, and so format, of the output workbooks: xlsx, xlsm, xltx, xltm or xlam")
+	sortSheetsFlag := flag.String("sort-sheets", "none", "With -s, order the sheets by CSV file name (name), by CSV path (path) or in scan order (none)")
+	activeFlag := flag.String("active", "", "With -s, open the workbook on this sheet, given by sheet name or source file name")
+	qualifyNamesFlag := flag.Bool("qualify-names", false, "With -s, name the sheets after the path of the CSV relative to the directory (e.g. sales_jan)")
+	colorByFolderFlag := flag.Bool("color-by-folder", false, "With -s, give the sheets of the CSVs of the same folder the same tab color")
+	perDirFlag := flag.Bool("per-dir", false, "With -s, scan the subdirectories and write one workbook per folder holding CSV files")
+	freezeColsFlag := flag.Int("freeze-cols", 0, "Freeze the leftmost N columns so they stay visible while scrolling right")
+	progressFlag := flag.Bool("progress", false, "In directory mode, print [done/total] after each file to standard error")
+	validateFlag := flag.Bool("validate", false, "Check that the CSV files parse and have a consistent number of fields, without writing any workbook")
+	sourceColFlag := flag.String("source-col", "", "Prepend a column with this header holding the name of the source CSV file in every data row")
+	appendFlag := flag.Bool("append", false, "With -d, stack the rows of all CSV files into a single sheet, writing the header of the first file only")
+	watchFlag := flag.Bool("watch", false, "With -d, keep running and convert the CSV files created or modified in the directory")
+	failFastFlag := flag.Bool("fail-fast", false, "With -d or archives, stop at the first file that fails to convert")
+	noClobberFlag := flag.Bool("no-clobber", false, "Skip the conversions whose output file already exists instead of overwriting it")
+	forceFlag := flag.Bool("force", false, "Overwrite existing output files (the default), overriding -no-clobber")
+	incrementalFlag := flag.Bool("incremental", false, "With -d, skip the CSV files unchanged since their last conversion, tracked in "+cacheFileName)
 	singleFileFlag := flag.Bool("s", false, "In directory mode, create a single Excel file with multiple sheets instead of separate files")
+	verboseFlag := flag.Bool("v", false, "Verbose output (e.g. report the detected encoding, separator and row/column counts)")
+	quietFlag := flag.Bool("q", false, "Quiet output: only errors, warnings and the final summary")
+	datesFlag := flag.Bool("dates", false, "Convert values matching -date-format into Excel dates")
+	dateFormatFlag := flag.String("date-format", "2006-01-02,02/01/2006,2006-01-02 15:04:05", "Comma-separated Go layouts tried, in order, to parse dates with -dates")
+	dateOutFlag := flag.String("date-out", "yyyy-mm-dd", "Excel number format used to display dates converted with -dates")
+	commentFlag := flag.String("comment", "", "Skip lines starting with this character (e.g. #)")
+	encodingFlag := flag.String("encoding", "utf8", "Input encoding for files without BOM: utf8, latin1 or windows1252")
+	strictUTF8Flag := flag.Bool("strict-utf8", false, "Fail on invalid UTF-8 instead of replacing it with U+FFFD")
+	decimalFlag := flag.String("decimal", "point", "Decimal separator of numbers: point (1,234.56) or comma (1.234,56)")
+	floatDecimalsFlag := flag.Int("float-decimals", 0, "Display the numeric columns holding fractional numbers with N decimals (0 to keep the default display)")
+	numFormatFlag := flag.String("num-format", "", "Excel number format applied to the columns holding only numbers (e.g. #,##0.00)")
+	noTypingFlag := flag.Bool("no-typing", false, "Store every value as text instead of detecting numbers")
+	sepFlag := flag.String("sep", "", "Field separator: a single character such as , ; | or \\t for tab (default: auto-detect)")
+	sepLiteralFlag := flag.String("sep-literal", "", "Field separator of several characters (e.g. || or ~|~), splitting lines without CSV quote processing")
+	sepRegexFlag := flag.String("sep-regex", "", "Regular expression matching the field separator (e.g. \\s*\\|\\s*), splitting lines without CSV quote processing")
+	updatedCellFlag := flag.String("updatedcell", "", "Write a bold \"last updated\" timestamp into this cell (e.g. A1) and start the data below it")
+	updatedFormatFlag := flag.String("updatedfmt", "yyyy-mm-dd hh:mm:ss", "Excel number format used for the -updatedcell timestamp")
+	durationsFlag := flag.Bool("durations", false, "Convert columns containing only ISO-8601 durations (e.g. PT1H30M) to Excel time values")
+	currencyFlag := flag.Bool("currency", false, "Convert columns containing only currency amounts (e.g. $1,234.56, €99,90) to formatted numbers")
+	percentFlag := flag.Bool("percent", false, "Convert columns containing only percentages (e.g. 12.5%) to fractions (0.125) displayed as percentages")
+	percentFormatFlag := flag.String("percent-format", "0.0%", "Excel number format of the -percent columns")
+	colorScaleFlag := flag.String("colorscale", "", "Apply a color scale to the numeric values of this column (letter or 1-based number)")
+	heatmapFlag := flag.Bool("heatmap", false, "Apply the -colorscale-colors color scale to every column holding numbers only, below the header")
+	colorScaleColorsFlag := flag.String("colorscale-colors", "#F8696B,#FFEB84,#63BE7B", "Comma-separated color scale colors from lowest to highest value (2 or 3 colors)")
+	summaryPanelFlag := flag.String("summarypanel", "", "Write frozen COUNT/SUM/AVERAGE formulas for this column (letter or 1-based number) above the data")
+	minWidthFlag := flag.Int("min-width", 8, "Minimum automatic column width")
+	maxWidthFlag := flag.Int("max-width", 100, "Maximum automatic column width")
+	widthFactorFlag := flag.Float64("width-factor", 1.2, "Multiplier from the characters of the longest value to the column width")
+	fixedWidthFlag := flag.Float64("fixed-width", 0, "Width of every used column, skipping the automatic widths (0 for automatic)")
+	smartWidthFlag := flag.Bool("smart-width", false, "Count East Asian wide characters as two in the column widths")
+	boolsFlag := flag.Bool("bools", false, "Convert columns containing only true/false tokens (e.g. yes/no) to Excel booleans")
+	trueValuesFlag := flag.String("true-values", "true,yes", "Comma-separated tokens read as TRUE by -bools (case-insensitive)")
+	falseValuesFlag := flag.String("false-values", "false,no", "Comma-separated tokens read as FALSE by -bools (case-insensitive)")
+	headerFlag := flag.String("header", "never", "Bold and freeze the first row as a header: always, never or auto (when it looks like one)")
+	hyperlinksFlag := flag.Bool("hyperlinks", false, "Store values that are http:// or https:// URLs as clickable links")
+	addHeaderFlag := flag.String("add-header", "", "Write these comma-separated column titles as the header before the CSV rows (e.g. Id,Name,Total)")
+	columnsFlag := flag.String("columns", "", "Write only these CSV columns, in this order, optionally renaming the header (e.g. 3,1:Name,E)")
+	transposeFlag := flag.Bool("transpose", false, "Write each CSV record as a column instead of a row (the whole file is read in memory first)")
+	typesFlag := flag.String("types", "", "Cell types forced on some columns, overriding the detection (e.g. A=text,B=number,C=date)")
+	colWidthFlag := flag.String("col-width", "", "Fixed column widths overriding the automatic ones (e.g. A=20,C=50)")
+	wrapFlag := flag.Bool("wrap", false, "Wrap the text of columns whose content is wider than -max-width instead of cutting it off")
+	wrapLinesFlag := flag.Bool("wrap-lines", false, "Wrap the text of the cells holding line breaks, so that each line shows on its own")
+	fontFlag := flag.String("font", "", "Font family of the written cells, e.g. Arial (default: the workbook default, Calibri)")
+	fontSizeFlag := flag.Float64("font-size", 0, "Font size of the written cells in points (default: the workbook default, 11)")
+	alignByTypeFlag := flag.Bool("align-by-type", false, "Right-align mostly numeric or date columns and left-align text columns")
+	zebraFlag := flag.Bool("zebra", false, "Shade every other data row below the header with a light fill")
+	groupStripeFlag := flag.String("groupstripe", "", "Shade each run of equal values in this key column (letter or 1-based number) with alternating fills")
+	keepDefaultSheetFlag := flag.Bool("keep-default-sheet", false, "Keep the empty default sheet (Sheet1) instead of deleting it")
+	autoFilterFlag := flag.Bool("autofilter", false, "Enable autofilter dropdowns on the header row")
+	tableFlag := flag.Bool("table", false, "Format the header and data as an Excel table (banded rows, filters, structured references)")
+	headerOnlyFlag := flag.Bool("headeronly", false, "Write only the first (header) row, styled, to produce an empty template")
+	appendToBookFlag := flag.String("append-to", "", "Add the converted CSVs as new sheets of this existing workbook instead of creating a new one")
+	appendToFlag := flag.String("appendto", "", "Append the CSV rows to a sheet of an existing workbook (workbook.xlsx:SheetName)")
+	metricsFileFlag := flag.String("metricsfile", "", "Write run metrics in Prometheus textfile format to this path")
+	logFlag := flag.String("log", "", "Append timestamped details of each file processed (output, rows, columns, duration, errors) to this file")
+	jsonFlag := flag.Bool("json", false, "Print a JSON report of the run (per-file results and totals) to stdout instead of the usual messages")
+	reverseFlag := flag.Bool("reverse", false, "With -f file.xlsx, convert each sheet back to a CSV file named after the sheet")
+	streamFlag := flag.Bool("stream", false, "Stream rows to the XLSX file to keep memory use low on large CSVs (single-sheet output only)")
+	skipLinesFlag := flag.Int("skip-lines", 0, "Discard the first N CSV records (e.g. metadata lines before the real header)")
+	rowsPerSheetFlag := flag.Int("rows-per-sheet", 0, "Split each CSV over sheets Name_1, Name_2, ... of at most N data rows, repeating the header (0 for one sheet)")
+	maxRowsFlag := flag.Int("max-rows", 0, "Stop after writing N data rows below the header (0 for no limit)")
+	maxCellLenFlag := flag.Int("max-cell-len", 32767, "Truncate values longer than N characters, ending them with … (at most Excel's 32767)")
+	splitLongCellsFlag := flag.Bool("split-long-cells", false, "Spill the characters of values over -max-cell-len into the next cells instead of truncating them")
+	padFlag := flag.Bool("pad", false, "Pad rows with fewer fields than the widest one with empty cells, so the sheet is rectangular")
+	trimFlag := flag.Bool("trim", false, "Remove leading and trailing whitespace from every value")
+	keepQuotesFlag := flag.Bool("keep-quotes", false, "Keep quotes at the start or end of values (e.g. the escaped quotes of \"\"\"hi\"\"\") instead of removing them")
+	rawFlag := flag.Bool("raw", false, "Split each line on the separator with no CSV quote processing, keeping the text between separators as is")
+	skipEmptyFilesFlag := flag.Bool("skip-empty-files", false, "Skip the CSV files without data rows (empty or header only) instead of marking their sheet (empty)")
+	skipEmptyFlag := flag.Bool("skip-empty", false, "Do not write rows whose fields are all empty (e.g. blank separator rows)")
+	skipHeaderFlag := flag.Bool("skipheader", false, "Do not write the first CSV row (e.g. when appending to a sheet that already has a header)")
+	configFlag := flag.String("config", "", "Read flag values from this JSON file (e.g. {\"sep\": \";\", \"zebra\": true}); command-line flags win")
+	presetFlag := flag.String("preset", "", "Enable a bundle of options: report (bold frozen header, autofilter, zebra, smart widths) or plain; other flags win")
+	versionFlag := flag.Bool("version", false, "Print the version of the tool, Go and excelize, then exit")
 
 	// Customize help message
 	flag.Usage = customHelp
@@ -26,6 +603,28 @@ func main() {
 	// Parse flags
 	flag.Parse()
 
+	// The version is printed whatever the other flags are
+	if *versionFlag {
+		printVersion()
+		os.Exit(0)
+	}
+
+	// Fill in the flags not given on the command line from the config file
+	if *configFlag != "" {
+		if err := applyConfigFile(*configFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -config file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Then from the preset, which may come from the config file too
+	if *presetFlag != "" {
+		if err := applyPreset(*presetFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -preset value: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// If help was explicitly requested, show it and exit
 	for _, arg := range os.Args[1:] {
 		if arg == "-h" || arg == "--help" {
@@ -34,273 +633,2651 @@ func main() {
 		}
 	}
 
+	// A manifest replaces the scan of -d, its directory taking the place
+	// of the scanned one
+	if *listFlag != "" {
+		if *fileFlag != "" || *dirFlag != "" || *watchFlag || *keepTreeFlag {
+			fmt.Fprintln(os.Stderr, "Error: -list cannot be combined with -f, -d, -watch or -keep-tree")
+			os.Exit(1)
+		}
+		*dirFlag = filepath.Dir(*listFlag)
+	}
+
 	// Verify that at least one of the mandatory flags is specified
 	if *fileFlag == "" && *dirFlag == "" {
-		fmt.Println("Error: You must specify either -f (file) or -d (directory)")
+		fmt.Fprintln(os.Stderr, "Error: You must specify either -f (file), -d (directory) or -list (manifest)")
 		customHelp()
 		os.Exit(1)
 	}
 
 	// Verify that both flags are not specified together
 	if *fileFlag != "" && *dirFlag != "" {
-		fmt.Println("Error: Specify either -f or -d, not both")
+		fmt.Fprintln(os.Stderr, "Error: Specify either -f or -d, not both")
 		os.Exit(1)
 	}
 
-	// Process based on the specified flag
-	if *fileFlag != "" {
-		// Single file mode
-		err := processFile(*fileFlag, "")
+	// A single output name makes no sense for a whole directory
+	if *outputFlag != "" && *dirFlag != "" {
+		fmt.Fprintln(os.Stderr, "Error: -o can only be used with -f, not with -d")
+		os.Exit(1)
+	}
+	if (*outDirFlag != "" || *keepTreeFlag) && *dirFlag == "" {
+		fmt.Fprintln(os.Stderr, "Error: -outdir and -keep-tree can only be used with -d")
+		os.Exit(1)
+	}
+	if *keepTreeFlag && *outDirFlag == "" {
+		fmt.Fprintln(os.Stderr, "Error: -keep-tree requires -outdir")
+		os.Exit(1)
+	}
+	if *outputFlag != "" && *appendToFlag != "" {
+		fmt.Fprintln(os.Stderr, "Error: -o cannot be combined with -appendto")
+		os.Exit(1)
+	}
+
+	// Standard output carries a single new workbook
+	if *stdoutFlag {
+		if *dirFlag != "" {
+			fmt.Fprintln(os.Stderr, "Error: -stdout can only be used with -f: a directory run writes several workbooks")
+			os.Exit(1)
+		}
+		if *outputFlag != "" || *appendToFlag != "" || *appendToBookFlag != "" || *reverseFlag || *validateFlag || *jsonFlag || isTarGz(*fileFlag) {
+			fmt.Fprintln(os.Stderr, "Error: -stdout cannot be combined with -o, -appendto, -append-to, -reverse, -validate, -json or an archive")
+			os.Exit(1)
+		}
+	}
+
+	if *quietFlag && *verboseFlag {
+		fmt.Fprintln(os.Stderr, "Error: Specify either -q or -v, not both")
+		os.Exit(1)
+	}
+
+	// The verbose messages would mix with the JSON report on stdout
+	if *jsonFlag && *verboseFlag {
+		fmt.Fprintln(os.Stderr, "Error: -json cannot be combined with -v")
+		os.Exit(1)
+	}
+
+	// Adding the sheets of a directory to a workbook works like -s
+	multiSheet := *singleFileFlag || (*dirFlag != "" && *appendToBookFlag != "")
+
+	if *indexFlag && !multiSheet {
+		fmt.Fprintln(os.Stderr, "Error: -index can only be used with -s")
+		os.Exit(1)
+	}
+	if *colorByFolderFlag && !multiSheet {
+		fmt.Fprintln(os.Stderr, "Error: -color-by-folder can only be used with -s")
+		os.Exit(1)
+	}
+	if *perDirFlag && (!*singleFileFlag || *appendToBookFlag != "" || *listFlag != "") {
+		fmt.Fprintln(os.Stderr, "Error: -per-dir requires -s and cannot be combined with -append-to or -list")
+		os.Exit(1)
+	}
+	// Validation only reads the CSV files
+	if *validateFlag && (*reverseFlag || *appendToFlag != "" || *appendFlag || *singleFileFlag || *watchFlag || (*fileFlag != "" && isTarGz(*fileFlag))) {
+		fmt.Fprintln(os.Stderr, "Error: -validate cannot be combined with -reverse, -appendto, -append, -s, -watch or an archive")
+		os.Exit(1)
+	}
+
+	// New sheets go into a workbook that already exists
+	if *appendToBookFlag != "" {
+		if *appendToFlag != "" || *outputFlag != "" || *outDirFlag != "" || *reverseFlag || *watchFlag || *appendFlag || *validateFlag || (*fileFlag != "" && isTarGz(*fileFlag)) {
+			fmt.Fprintln(os.Stderr, "Error: -append-to cannot be combined with -appendto, -o, -outdir, -reverse, -watch, -append, -validate or an archive")
+			os.Exit(1)
+		}
+		if *streamFlag || *rowsPerSheetFlag > 0 {
+			fmt.Fprintln(os.Stderr, "Error: -append-to cannot be combined with -stream or -rows-per-sheet")
+			os.Exit(1)
+		}
+	}
+
+	// Stacking writes one sheet from several files
+	if *appendFlag {
+		if *dirFlag == "" || *singleFileFlag || *watchFlag {
+			fmt.Fprintln(os.Stderr, "Error: -append requires -d and cannot be combined with -s or -watch")
+			os.Exit(1)
+		}
+		if *updatedCellFlag != "" || *summaryPanelFlag != "" || *autoFilterFlag || *tableFlag || *rowsPerSheetFlag > 0 || *streamFlag {
+			fmt.Fprintln(os.Stderr, "Error: -append cannot be combined with -updatedcell, -summarypanel, -autofilter, -table, -rows-per-sheet or -stream")
+			os.Exit(1)
+		}
+	}
+
+	// Existing workbooks are updated on purpose by these modes
+	if *noClobberFlag && !*forceFlag && (*appendToFlag != "" || *appendToBookFlag != "") {
+		fmt.Fprintln(os.Stderr, "Error: -no-clobber cannot be combined with -appendto or -append-to")
+		os.Exit(1)
+	}
+
+	// The cache describes the conversions of a directory to new workbooks
+	if *incrementalFlag && (*dirFlag == "" || *appendToBookFlag != "" || *watchFlag || *validateFlag) {
+		fmt.Fprintln(os.Stderr, "Error: -incremental requires -d and cannot be combined with -append-to, -watch or -validate")
+		os.Exit(1)
+	}
+
+	// Watch mode converts each file on its own as it changes
+	if *watchFlag && (*dirFlag == "" || *singleFileFlag || *jsonFlag) {
+		fmt.Fprintln(os.Stderr, "Error: -watch requires -d and cannot be combined with -s or -json")
+		os.Exit(1)
+	}
+
+	if (*qualifyNamesFlag || *namesFlag != "") && !*singleFileFlag {
+		fmt.Fprintln(os.Stderr, "Error: -qualify-names and -names can only be used with -s")
+		os.Exit(1)
+	}
+
+	// A table brings its own filter dropdowns
+	if *tableFlag && *autoFilterFlag {
+		fmt.Fprintln(os.Stderr, "Error: Specify either -table or -autofilter, not both")
+		os.Exit(1)
+	}
+
+	// Validate the timestamp cell
+	if *updatedCellFlag != "" {
+		if _, _, err := excelize.CellNameToCoordinates(*updatedCellFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -updatedcell value %q: %v\n", *updatedCellFlag, err)
+			os.Exit(1)
+		}
+	}
+
+	// Validate the separator (detected per file when not given)
+	var separator rune
+	if *sepFlag != "" {
+		var err error
+		separator, err = parseSeparator(*sepFlag)
 		if err != nil {
-			fmt.Printf("Error during file conversion: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error: invalid -sep value: %v\n", err)
 			os.Exit(1)
 		}
-	} else {
-		// Directory mode
-		if *singleFileFlag {
-			// Single file with multiple sheets mode
-			err := processDirectoryToSingleFile(*dirFlag)
-			if err != nil {
-				fmt.Printf("Error during directory conversion: %v\n", err)
-				os.Exit(1)
-			}
-		} else {
-			// Separate files mode
-			err := processDirectory(*dirFlag)
-			if err != nil {
-				fmt.Printf("Error during directory conversion: %v\n", err)
-				os.Exit(1)
-			}
+	}
+	separatorsGiven := 0
+	for _, value := range []string{*sepFlag, *sepLiteralFlag, *sepRegexFlag} {
+		if value != "" {
+			separatorsGiven++
+		}
+	}
+	if separatorsGiven > 1 {
+		fmt.Fprintln(os.Stderr, "Error: only one of -sep, -sep-literal and -sep-regex can be given")
+		os.Exit(1)
+	}
+	if strings.ContainsAny(*sepLiteralFlag, "\r\n") {
+		fmt.Fprintln(os.Stderr, "Error: invalid -sep-literal value: it cannot contain line breaks")
+		os.Exit(1)
+	}
+	var separatorRegexp *regexp.Regexp
+	if *sepRegexFlag != "" {
+		var err error
+		separatorRegexp, err = regexp.Compile(*sepRegexFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -sep-regex value: %v\n", err)
+			os.Exit(1)
+		}
+		if separatorRegexp.MatchString("") {
+			fmt.Fprintf(os.Stderr, "Error: invalid -sep-regex value: %q matches empty text\n", *sepRegexFlag)
+			os.Exit(1)
+		}
+	}
+
+	// Validate the comment character, which encoding/csv requires to differ from the separator
+	var comment rune
+	if *commentFlag != "" {
+		var err error
+		comment, err = parseCommentChar(*commentFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -comment value: %v\n", err)
+			os.Exit(1)
 		}
+		if comment == separator {
+			fmt.Fprintln(os.Stderr, "Error: -comment and -sep must be different characters")
+			os.Exit(1)
+		}
+	}
+
+	// Validate the enumerated values
+	if *decimalFlag != "point" && *decimalFlag != "comma" {
+		fmt.Fprintln(os.Stderr, "Error: -decimal must be point or comma")
+		os.Exit(1)
+	}
+	if *sortSheetsFlag != "none" && *sortSheetsFlag != "name" && *sortSheetsFlag != "path" {
+		fmt.Fprintln(os.Stderr, "Error: -sort-sheets must be none, name or path")
+		os.Exit(1)
+	}
+	outputExt := strings.ToLower(strings.TrimPrefix(*extFlag, "."))
+	if outputExt == "xls" {
+		fmt.Fprintln(os.Stderr, "Error: -ext xls is not supported: the legacy .xls format cannot be written, use xlsx")
+		os.Exit(1)
+	}
+	if !slices.Contains(outputExts, outputExt) {
+		fmt.Fprintf(os.Stderr, "Error: -ext must be one of %s\n", strings.Join(outputExts, ", "))
+		os.Exit(1)
+	}
+	if *stdoutFlag && outputExt != "xlsx" {
+		fmt.Fprintln(os.Stderr, "Error: -stdout writes xlsx workbooks only, -ext must be xlsx")
+		os.Exit(1)
+	}
+	headerModes := map[string]csvxls.HeaderMode{"never": csvxls.HeaderNever, "always": csvxls.HeaderAlways, "auto": csvxls.HeaderAuto}
+	headerMode, ok := headerModes[*headerFlag]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Error: -header must be always, never or auto")
+		os.Exit(1)
+	}
+
+	// Validate the input encoding
+	inputEncoding, err := lookupEncoding(*encodingFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid -encoding value: %v\n", err)
+		os.Exit(1)
+	}
+
+	opts := options{
+		Options: csvxls.Options{
+			Separator:        separator,
+			SeparatorLiteral: *sepLiteralFlag,
+			SeparatorRegexp:  separatorRegexp,
+			Comment:          comment,
+			Encoding:         inputEncoding,
+			StrictUTF8:       *strictUTF8Flag,
+			Raw:              *rawFlag,
+			TypeNumbers:      !*noTypingFlag,
+			NumFormat:        *numFormatFlag,
+			FloatDecimals:    *floatDecimalsFlag,
+			DecimalComma:     *decimalFlag == "comma",
+			DateFormat:       *dateOutFlag,
+			Verbose:          *verboseFlag,
+			Quiet:            *quietFlag || *jsonFlag,
+			UpdatedCell:      strings.ToUpper(*updatedCellFlag),
+			UpdatedFormat:    *updatedFormatFlag,
+			Durations:        *durationsFlag,
+			Bools:            *boolsFlag,
+			TrueValues:       splitTokenList(*trueValuesFlag),
+			FalseValues:      splitTokenList(*falseValuesFlag),
+			Currency:         *currencyFlag,
+			Percent:          *percentFlag,
+			PercentFormat:    *percentFormatFlag,
+			Zebra:            *zebraFlag,
+			AlignByType:      *alignByTypeFlag,
+			HeaderOnly:       *headerOnlyFlag,
+			Header:           headerMode,
+			AutoFilter:       *autoFilterFlag,
+			Table:            *tableFlag,
+
+			SheetName:        *sheetFlag,
+			Password:         *passwordFlag,
+			Title:            *titleFlag,
+			Author:           *authorFlag,
+			Company:          *companyFlag,
+			MinWidth:         *minWidthFlag,
+			MaxWidth:         *maxWidthFlag,
+			WidthFactor:      *widthFactorFlag,
+			SmartWidth:       *smartWidthFlag,
+			FixedWidth:       *fixedWidthFlag,
+			Wrap:             *wrapFlag,
+			WrapLines:        *wrapLinesFlag,
+			Font:             strings.TrimSpace(*fontFlag),
+			FontSize:         *fontSizeFlag,
+			KeepDefaultSheet: *keepDefaultSheetFlag,
+			SkipHeader:       *skipHeaderFlag,
+			SkipEmpty:        *skipEmptyFlag,
+			SkipEmptyFiles:   *skipEmptyFilesFlag,
+			KeepQuotes:       *keepQuotesFlag,
+			Trim:             *trimFlag,
+			Transpose:        *transposeFlag,
+			Pad:              *padFlag,
+			SkipLines:        *skipLinesFlag,
+			MaxRows:          *maxRowsFlag,
+			MaxCellLen:       *maxCellLenFlag,
+			SplitLongCells:   *splitLongCellsFlag,
+			Hyperlinks:       *hyperlinksFlag,
+			FreezeCols:       *freezeColsFlag,
+			SourceColumn:     *sourceColFlag,
+			RowsPerSheet:     *rowsPerSheetFlag,
+			Stream:           *streamFlag,
+		},
+		outputPath: *outputFlag,
+		outputDir:  *outDirFlag,
+		keepTree:   *keepTreeFlag,
+		excludes:   excludeFlag,
+		recursive:  recursiveFlag || *perDirFlag,
+		index:      *indexFlag,
+		active:     *activeFlag,
+		sortSheets: *sortSheetsFlag,
+		workbook:   *appendToBookFlag,
+		ext:        "." + outputExt,
+		qualify:    *qualifyNamesFlag,
+		colorByDir: *colorByFolderFlag,
+		perFolder:  *perDirFlag,
+		failFast:   *failFastFlag,
+		jsonOutput: *jsonFlag,
+		progress:   *progressFlag,
+
+		incremental: *incrementalFlag,
+		stdout:      *stdoutFlag,
+		noClobber:   *noClobberFlag && !*forceFlag,
+		force:       *forceFlag,
+	}
+
+	// Keep standard output for the workbook
+	if opts.stdout {
+		opts.Messages = os.Stderr
+	}
+
+	// Load the sheet names mapped to the CSV files
+	if *namesFlag != "" {
+		sheetMap, err := loadSheetNameMap(*namesFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -names file: %v\n", err)
+			os.Exit(1)
+		}
+		opts.sheetMap = sheetMap
+	}
+
+	// Load the CSV files listed by the manifest
+	if *listFlag != "" {
+		listFiles, err := loadFileList(*listFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -list manifest: %v\n", err)
+			os.Exit(1)
+		}
+		opts.listPath = *listFlag
+		opts.listFiles = listFiles
+	}
+
+	// The password from the environment wins over the flag
+	if password := os.Getenv(passwordEnvVar); password != "" {
+		opts.Password = password
+	}
+
+	// Collect the date layouts, used by -dates and the date columns of -types
+	var dateLayouts []string
+	for _, layout := range strings.Split(*dateFormatFlag, ",") {
+		if layout = strings.TrimSpace(layout); layout != "" {
+			dateLayouts = append(dateLayouts, layout)
+		}
+	}
+	if *datesFlag {
+		if len(dateLayouts) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: -date-format must list at least one layout")
+			os.Exit(1)
+		}
+		opts.DateLayouts = dateLayouts
+	}
+
+	// Validate the color scale column and colors
+	if *colorScaleFlag != "" {
+		col, err := parseColumnRef(*colorScaleFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -colorscale value: %v\n", err)
+			os.Exit(1)
+		}
+		opts.ColorScaleCol = col
+	}
+	if *colorScaleFlag != "" || *heatmapFlag {
+		colors, err := parseColorList(*colorScaleColorsFlag)
+		if err != nil || len(colors) < 2 || len(colors) > 3 {
+			fmt.Fprintf(os.Stderr, "Error: -colorscale-colors must list 2 or 3 colors in #RRGGBB format\n")
+			os.Exit(1)
+		}
+		opts.ColorScaleColors = colors
+		opts.Heatmap = *heatmapFlag
+	}
+
+	// Validate the column width settings
+	if opts.MinWidth < 1 || opts.MaxWidth > maxColumnWidth || opts.MinWidth > opts.MaxWidth {
+		fmt.Fprintf(os.Stderr, "Error: -min-width and -max-width must satisfy 1 <= min <= max <= %d\n", maxColumnWidth)
+		os.Exit(1)
+	}
+	if opts.WidthFactor <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: -width-factor must be greater than 0")
+		os.Exit(1)
+	}
+	if opts.FixedWidth < 0 || opts.FixedWidth > maxColumnWidth {
+		fmt.Fprintf(os.Stderr, "Error: -fixed-width must be between 0 and %d\n", maxColumnWidth)
+		os.Exit(1)
+	}
+	if opts.FixedWidth > 0 && opts.Wrap {
+		fmt.Fprintln(os.Stderr, "Error: -wrap cannot be combined with -fixed-width")
+		os.Exit(1)
+	}
+
+	// Validate the font, within the limits of Excel
+	if utf8.RuneCountInString(opts.Font) > excelize.MaxFontFamilyLength {
+		fmt.Fprintf(os.Stderr, "Error: -font can have at most %d characters\n", excelize.MaxFontFamilyLength)
+		os.Exit(1)
+	}
+	if opts.FontSize != 0 && (opts.FontSize < excelize.MinFontSize || opts.FontSize > excelize.MaxFontSize) {
+		fmt.Fprintf(os.Stderr, "Error: -font-size must be between %d and %d\n", excelize.MinFontSize, excelize.MaxFontSize)
+		os.Exit(1)
+	}
+	for _, token := range opts.TrueValues {
+		if slices.ContainsFunc(opts.FalseValues, func(t string) bool { return strings.EqualFold(t, token) }) {
+			fmt.Fprintf(os.Stderr, "Error: %q is in both -true-values and -false-values\n", token)
+			os.Exit(1)
+		}
+	}
+	if *addHeaderFlag != "" {
+		header, err := parseHeaderList(*addHeaderFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -add-header value: %v\n", err)
+			os.Exit(1)
+		}
+		opts.AddHeader = header
+	}
+	if *columnsFlag != "" {
+		columns, err := parseColumnList(*columnsFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -columns value: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Columns = columns
+	}
+	if *colWidthFlag != "" {
+		colWidths, err := parseColumnWidths(*colWidthFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -col-width value: %v\n", err)
+			os.Exit(1)
+		}
+		opts.ColWidths = colWidths
+	}
+	if *typesFlag != "" {
+		columnTypes, err := parseColumnTypes(*typesFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -types value: %v\n", err)
+			os.Exit(1)
+		}
+		if slices.Contains(slices.Collect(maps.Values(columnTypes)), csvxls.TypeDate) && len(dateLayouts) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: -date-format must list at least one layout")
+			os.Exit(1)
+		}
+		opts.ColumnTypes = columnTypes
+		opts.ColumnDateLayouts = dateLayouts
+	}
+
+	// Validate the summary panel column
+	if *summaryPanelFlag != "" {
+		col, err := parseColumnRef(*summaryPanelFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -summarypanel value: %v\n", err)
+			os.Exit(1)
+		}
+		opts.SummaryCol = col
+	}
+
+	// Validate the group stripe key column
+	if *groupStripeFlag != "" {
+		col, err := parseColumnRef(*groupStripeFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid -groupstripe value: %v\n", err)
+			os.Exit(1)
+		}
+		opts.GroupStripeCol = col
+	}
+
+	if opts.SkipLines < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -skip-lines must not be negative")
+		os.Exit(1)
+	}
+	if opts.MaxRows < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -max-rows must not be negative")
+		os.Exit(1)
+	}
+	if opts.RowsPerSheet < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -rows-per-sheet must not be negative")
+		os.Exit(1)
+	}
+	if opts.MaxCellLen < 1 || opts.MaxCellLen > 32767 {
+		fmt.Fprintln(os.Stderr, "Error: -max-cell-len must be between 1 and 32767, the most characters an Excel cell holds")
+		os.Exit(1)
+	}
+	if opts.FloatDecimals < 0 || opts.FloatDecimals > maxFloatDecimals {
+		fmt.Fprintf(os.Stderr, "Error: -float-decimals must be between 0 and %d\n", maxFloatDecimals)
+		os.Exit(1)
+	}
+	if opts.FreezeCols < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -freeze-cols must not be negative")
+		os.Exit(1)
+	}
+
+	// Splitting turns one CSV into a workbook of its own
+	if opts.RowsPerSheet > 0 && (*singleFileFlag || *appendToFlag != "" || (*fileFlag != "" && isTarGz(*fileFlag))) {
+		fmt.Fprintln(os.Stderr, "Error: -rows-per-sheet cannot be combined with -s, -appendto or archives")
+		os.Exit(1)
+	}
+
+	// An explicit sheet name only fits conversions producing a single sheet
+	if *sheetFlag != "" && (*fileFlag == "" || isTarGz(*fileFlag) || *appendToFlag != "" || *reverseFlag) {
+		fmt.Fprintln(os.Stderr, "Error: -sheet can only be used with -f on a CSV file (not with -d, archives, -appendto or -reverse)")
+		os.Exit(1)
+	}
+
+	// Appending needs a single source file
+	if *appendToFlag != "" && *fileFlag == "" {
+		fmt.Fprintln(os.Stderr, "Error: -appendto can only be used with -f")
+		os.Exit(1)
+	}
+
+	// The timestamp cell, summary panel, autofilter and table only make sense in a fresh sheet
+	if *appendToFlag != "" {
+		conflicts := []struct {
+			name string
+			set  bool
+		}{
+			{"-updatedcell", opts.UpdatedCell != ""},
+			{"-summarypanel", opts.SummaryCol > 0},
+			{"-autofilter", opts.AutoFilter},
+			{"-table", opts.Table},
+		}
+		for _, conflict := range conflicts {
+			if conflict.set {
+				fmt.Fprintf(os.Stderr, "Error: -appendto cannot be combined with %s\n", conflict.name)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Reverse mode reads a workbook, so the XLSX output options do not apply
+	if *reverseFlag {
+		if *fileFlag == "" || !strings.HasSuffix(strings.ToLower(*fileFlag), ".xlsx") {
+			fmt.Fprintln(os.Stderr, "Error: -reverse requires -f with an .xlsx file")
+			os.Exit(1)
+		}
+		if *outputFlag != "" || *appendToFlag != "" || opts.Stream {
+			fmt.Fprintln(os.Stderr, "Error: -reverse cannot be combined with -o, -appendto or -stream")
+			os.Exit(1)
+		}
+	}
+
+	// Streamed sheets cannot be read back, so post-processing and multi-sheet modes are out
+	if opts.Stream {
+		if *singleFileFlag || *appendToFlag != "" || (*fileFlag != "" && isTarGz(*fileFlag)) {
+			fmt.Fprintln(os.Stderr, "Error: -stream only supports single-sheet output (not -s, -appendto or archives)")
+			os.Exit(1)
+		}
+		conflicts := []struct {
+			name string
+			set  bool
+		}{
+			{"-updatedcell", opts.UpdatedCell != ""},
+			{"-durations", opts.Durations},
+			{"-currency", opts.Currency},
+			{"-percent", opts.Percent},
+			{"-colorscale", opts.ColorScaleCol > 0},
+			{"-summarypanel", opts.SummaryCol > 0},
+			{"-groupstripe", opts.GroupStripeCol > 0},
+			{"-zebra", opts.Zebra},
+			{"-wrap", opts.Wrap},
+			{"-autofilter", opts.AutoFilter},
+			{"-table", opts.Table},
+			{"-headeronly", opts.HeaderOnly},
+			{"-pad", opts.Pad},
+			{"-num-format", opts.NumFormat != ""},
+			{"-float-decimals", opts.FloatDecimals > 0},
+			{"-hyperlinks", opts.Hyperlinks},
+			{"-bools", opts.Bools},
+			{"-align-by-type", opts.AlignByType},
+			{"-rows-per-sheet", opts.RowsPerSheet > 0},
+			{"-heatmap", opts.Heatmap},
+			{"-transpose", opts.Transpose},
+		}
+		for _, conflict := range conflicts {
+			if conflict.set {
+				fmt.Fprintf(os.Stderr, "Error: -stream cannot be combined with %s\n", conflict.name)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Open the log of the run, closed once the run ends
+	if *logFlag != "" {
+		logWriter, err := openRunLog(*logFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: unable to open -log file: %v\n", err)
+			os.Exit(1)
+		}
+		runLog = log.New(logWriter, "", log.LstdFlags|log.Lmicroseconds)
+		runLog.Printf("run started: %s", strings.Join(os.Args, " "))
+		closeRunLog = logWriter.Close
+	}
+
+	// Cancel the conversion on Ctrl-C or SIGTERM; the file being converted
+	// is never saved, files completed earlier are kept
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Process based on the specified flag
+	var errContext string
+	if *validateFlag {
+		// Validation mode: no workbook is written
+		errContext = "validation"
+		if *fileFlag != "" {
+			err = validateFile(ctx, *fileFlag, opts)
+		} else {
+			err = validateDirectory(ctx, *dirFlag, opts)
+		}
+	} else if *reverseFlag {
+		// Reverse mode: one CSV per sheet of the workbook
+		errContext = "reverse conversion"
+		err = processReverse(*fileFlag, opts)
+	} else if *fileFlag != "" && isTarGz(*fileFlag) {
+		// Archive mode: one workbook with a sheet per CSV member
+		errContext = "archive conversion"
+		err = processArchive(ctx, *fileFlag, opts)
+	} else if *appendToBookFlag != "" && *fileFlag != "" {
+		// New sheet in an existing workbook
+		errContext = "append"
+		err = addFileToWorkbook(ctx, *fileFlag, *appendToBookFlag, opts)
+	} else if *appendToFlag != "" {
+		// Append to an existing workbook
+		errContext = "append"
+		err = appendFileToWorkbook(ctx, *fileFlag, *appendToFlag, opts)
+	} else if *fileFlag != "" {
+		// Single file mode
+		errContext = "file conversion"
+		err = processFile(ctx, *fileFlag, opts)
+	} else {
+		// Directory mode
+		errContext = "directory conversion"
+		if *watchFlag {
+			// Watch mode: convert the files as they change, until interrupted
+			err = processWatch(ctx, *dirFlag, opts)
+		} else if *appendFlag {
+			// Single sheet with the rows of all files
+			err = processDirectoryAppend(ctx, *dirFlag, opts)
+		} else if opts.perFolder {
+			// One file with multiple sheets per folder
+			err = processDirectoryPerFolder(ctx, *dirFlag, opts)
+		} else if multiSheet {
+			// Single file with multiple sheets mode, new or existing
+			err = processDirectoryToSingleFile(ctx, *dirFlag, opts)
+		} else {
+			// Separate files mode
+			err = processDirectory(ctx, *dirFlag, opts)
+		}
+	}
+
+	// A single file skipped by -skip-empty-files or -no-clobber is no failure
+	if errors.Is(err, csvxls.ErrNoData) {
+		opts.infof("Skipped %s: it has no data rows", *fileFlag)
+		err = nil
+	} else if errors.Is(err, errExists) {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %s: %v\n", *fileFlag, err)
+		err = nil
+	}
+
+	// Stopping -watch with Ctrl-C ends the run normally
+	interrupted := ctx.Err() != nil && !*watchFlag
+
+	// Write the end of the run to the log and close it, as os.Exit skips
+	// the deferred calls
+	if runLog != nil {
+		switch {
+		case interrupted:
+			runLog.Printf("run interrupted after %s", time.Since(metrics.start).Round(time.Millisecond))
+		case err != nil:
+			runLog.Printf("run failed after %s: %s: %v", time.Since(metrics.start).Round(time.Millisecond), errContext, err)
+		default:
+			runLog.Printf("run finished in %s", time.Since(metrics.start).Round(time.Millisecond))
+		}
+		runLog.Printf("totals: %d converted, %d failed, %d skipped, %d unchanged, %d rows", metrics.filesConverted, metrics.filesFailed, metrics.filesSkipped, metrics.filesUnchanged, metrics.Rows)
+		if closeErr := closeRunLog(); closeErr != nil && err == nil {
+			errContext = "logging"
+			err = fmt.Errorf("unable to write -log file: %v", closeErr)
+		}
+	}
+
+	// Write the run metrics, also for failed runs
+	if *metricsFileFlag != "" {
+		if metricsErr := writeMetricsFile(*metricsFileFlag); metricsErr != nil {
+			fmt.Fprintf(os.Stderr, "Error writing metrics file: %v\n", metricsErr)
+			if err == nil {
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Print the JSON report, also for failed runs
+	if opts.jsonOutput {
+		if reportErr := writeJSONReport(os.Stdout, err); reportErr != nil {
+			fmt.Fprintf(os.Stderr, "Error writing JSON report: %v\n", reportErr)
+			if err == nil {
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Interruption takes precedence over the error it caused
+	if interrupted {
+		fmt.Fprintln(os.Stderr, "Interrupted, cleaned up: the file being converted was not written")
+		os.Exit(130)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error during %s: %v\n", errContext, err)
+
+		// Exit with 2 when only some of the files failed, 1 otherwise
+		var batchErr *batchError
+		if errors.As(err, &batchErr) && batchErr.failed < batchErr.total {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// Flag values set by each -preset; plain is the output without options
+var presets = map[string]map[string]string{
+	"plain": {},
+	"report": {
+		"header":      "always",
+		"autofilter":  "true",
+		"zebra":       "true",
+		"smart-width": "true",
+	},
+}
+
+// Set the flags of a preset that were not given on the command line or in
+// the config file
+func applyPreset(name string) error {
+	values, ok := presets[name]
+	if !ok {
+		return fmt.Errorf("unknown preset %q (use %s)", name, strings.Join(slices.Sorted(maps.Keys(presets)), " or "))
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(fl *flag.Flag) {
+		explicit[fl.Name] = true
+	})
+
+	for _, flagName := range slices.Sorted(maps.Keys(values)) {
+		if explicit[flagName] {
+			continue
+		}
+		if err := flag.Set(flagName, values[flagName]); err != nil {
+			return fmt.Errorf("preset %s: flag -%s: %v", name, flagName, err)
+		}
+	}
+	return nil
+}
+
+// Set the flags listed in a JSON config file, an object mapping flag names
+// (without the dash) to values, unless they were given on the command line.
+// Lists set repeatable flags such as -exclude once per element
+func applyConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var values map[string]interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	// Flags given on the command line override the file
+	explicit := make(map[string]bool)
+	flag.Visit(func(fl *flag.Flag) {
+		explicit[fl.Name] = true
+	})
+
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		if flag.Lookup(name) == nil || name == "config" {
+			fmt.Fprintf(os.Stderr, "Warning: unknown key %q in config file %s, ignored\n", name, path)
+			continue
+		}
+		if explicit[name] {
+			continue
+		}
+
+		args, err := configValues(values[name])
+		if err != nil {
+			return fmt.Errorf("%s: key %q: %v", path, name, err)
+		}
+		for _, arg := range args {
+			if err := flag.Set(name, arg); err != nil {
+				return fmt.Errorf("%s: key %q: %v", path, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Convert a config file value to the flag arguments it stands for
+func configValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case json.Number:
+		return []string{v.String()}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case []interface{}:
+		var args []string
+		for _, element := range v {
+			elementArgs, err := configValues(element)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, elementArgs...)
+		}
+		return args, nil
+	}
+	return nil, fmt.Errorf("value must be a string, number, boolean or list")
+}
+
+// Custom function for help
+func customHelp() {
+	fmt.Println("Usage: csvtoxls [options]")
+	fmt.Println("\nOptions:")
+	fmt.Println("  -f file.csv     Converts a single CSV file to XLSX (use - to read standard input,")
+	fmt.Println("                  which requires -o); gzip-compressed .csv.gz files are decompressed")
+	fmt.Println("                  on the fly and converted to file.xlsx")
+	fmt.Println("  -f file.tsv     Converts a tab-separated file (tab is the default separator of .tsv")
+	fmt.Println("                  files); .txt files are converted too when -sep, -sep-literal or")
+	fmt.Println("                  -sep-regex gives their separator")
+	fmt.Println("  -f data.tar.gz  Converts every CSV in a .tar.gz/.tgz archive into one XLSX file with")
+	fmt.Println("                  one sheet per CSV")
+	fmt.Println("  -reverse        With -f file.xlsx, writes each sheet to a CSV named after the sheet")
+	fmt.Println("                  next to the workbook, using -sep (default ;) as separator; it stops")
+	fmt.Println("                  before writing anything if one of the CSV files exists, unless -force")
+	fmt.Println("                  overwrites them or -no-clobber skips their sheets")
+	fmt.Println("  -sheet name     With -f, names the sheet instead of deriving the name from the file")
+	fmt.Println("                  (invalid characters are replaced and the name is cut to 31 characters)")
+	fmt.Println("                  Sheet names never start or end with an apostrophe, and the name")
+	fmt.Println("                  History, reserved by Excel, becomes History_")
+	fmt.Println("  -password pw    Encrypts the XLSX output with the password; the password can also be")
+	fmt.Println("                  set in the " + passwordEnvVar + " environment variable, which keeps it out of")
+	fmt.Println("                  the shell history and wins over the flag. It is also used to open")
+	fmt.Println("                  the workbook of -appendto and -reverse")
+	fmt.Println("  -title text     Sets the Title document property of the workbook; new workbooks are")
+	fmt.Println("                  titled after the CSV file, directory or archive by default")
+	fmt.Println("  -author name    Sets the Author document property of the workbook")
+	fmt.Println("  -company name   Sets the Company document property of the workbook")
+	fmt.Println("  -o out.xlsx     With -f, writes the output to this path (.xlsx, or the -ext extension,")
+	fmt.Println("                  is appended if missing; missing directories are created)")
+	fmt.Println("  -stdout         With -f, writes the XLSX workbook to standard output instead of a file,")
+	fmt.Println("                  e.g. to pipe it to another program; the messages go to standard error.")
+	fmt.Println("                  Works with -f - to read standard input; not with -d, -o or -ext")
+	fmt.Println("  -ext xlsm       Extension, and so format, of the new workbooks: xlsx (default), xlsm")
+	fmt.Println("                  (macro-enabled), xltx, xltm (templates) or xlam (add-in). The legacy")
+	fmt.Println("                  xls format cannot be written")
+	fmt.Println("  -d directory    Converts all CSV files (.csv, .tsv and their .gz versions, plus .txt")
+	fmt.Println("                  with -sep) in the specified directory (subdirectories are not")
+	fmt.Println("                  scanned unless -r is given)")
+	fmt.Println("  -list manifest.txt")
+	fmt.Println("                  Converts the CSV files listed in the manifest, one path per line")
+	fmt.Println("                  relative to the manifest's directory, in the listed order, as -d")
+	fmt.Println("                  does for a directory (with -s, the sheets follow the list). Blank")
+	fmt.Println("                  lines and lines starting with # are ignored; a combined workbook is")
+	fmt.Println("                  named after the manifest. Not available with -watch or -keep-tree")
+	fmt.Println("  -r, -recursive  In directory mode, also converts CSV files in subdirectories")
+	fmt.Println("  -outdir dir     In directory mode, writes the XLSX files into dir (created if missing)")
+	fmt.Println("                  instead of next to each CSV")
+	fmt.Println("  -keep-tree      With -outdir, recreates the subdirectories of the scanned directory;")
+	fmt.Println("                  without it, CSVs with the same name in different subdirectories get")
+	fmt.Println("                  a numeric suffix (data.xlsx, data_1.xlsx, ...)")
+	fmt.Println("  -exclude pat    In directory mode, skips CSV files whose name matches the pattern")
+	fmt.Println("                  (e.g. *_bak.csv); repeat the flag or separate patterns with commas")
+	fmt.Println("  -validate       With -f or -d, only checks the CSV files: each one passes when it")
+	fmt.Println("                  parses and every row has as many fields as the first (after")
+	fmt.Println("                  -skip-lines); otherwise the offending lines are listed. No workbook")
+	fmt.Println("                  is written and the exit status is non-zero if any file fails")
+	fmt.Println("  -source-col H   Prepends a column with header H holding the name of the source CSV file")
+	fmt.Println("                  in every data row, e.g. to tell apart the rows of -append; column")
+	fmt.Println("                  numbers of the other options count it as column 1")
+	fmt.Println("  -append         In directory mode, writes the rows of all CSV files into a single sheet")
+	fmt.Println("                  of dir.xlsx, one file below the other, with the header of the first")
+	fmt.Println("                  file only; files whose header differs are reported, and a file that")
+	fmt.Println("                  fails to convert stops the run without writing the workbook")
+	fmt.Println("  -progress       In directory mode (also with -s, -append and -validate), prints")
+	fmt.Println("                  [done/total] and the percentage after each CSV file to standard")
+	fmt.Println("                  error; it is shown with -q and keeps the -json output clean")
+	fmt.Println("  -no-clobber     Skips, with a warning, each conversion whose output file (next to the")
+	fmt.Println("                  CSV, or the -o or -outdir path) already exists, instead of overwriting")
+	fmt.Println("                  it; skipped files are counted in the summary. Not with -appendto or")
+	fmt.Println("                  -append-to, which update existing files on purpose")
+	fmt.Println("  -force          Overwrites existing output files, the default except for the CSV")
+	fmt.Println("                  files of -reverse; overrides a -no-clobber set in the -config file")
+	fmt.Println("  -incremental    With -d, converts only the CSV files whose size or modification time")
+	fmt.Println("                  changed since their last conversion, or whose workbook is missing or")
+	fmt.Println("                  older; they are tracked in .csvtoxls-cache.json in the directory. With -s")
+	fmt.Println("                  or -append the workbook is rebuilt when any of its files changed, was")
+	fmt.Println("                  added or removed. Changing -outdir converts everything again, other")
+	fmt.Println("                  options are not tracked: delete the cache after changing them")
+	fmt.Println("  -watch          In directory mode, keeps running and converts each CSV file created or")
+	fmt.Println("                  modified in the directory (and its subdirectories with -r) once it")
+	fmt.Println("                  has not changed for a second; files already present are not")
+	fmt.Println("                  converted. Stops with Ctrl-C. Not available with -s or -json.")
+	fmt.Println("                  The directory is polled (scanned) every second rather than")
+	fmt.Println("                  watched through OS notifications: a file is converted 1 to 2")
+	fmt.Println("                  seconds after its last write, and each scan costs a directory")
+	fmt.Println("                  listing, noticeable only on trees of many thousands of files")
+	fmt.Println("  -s              In directory mode, creates a single Excel file with multiple sheets")
+	fmt.Println("                  instead of creating one XLSX file per CSV")
+	fmt.Println("  -per-dir        With -s, scans the subdirectories too (as -r) and writes one workbook")
+	fmt.Println("                  per folder holding CSV files, with a sheet per CSV of that folder,")
+	fmt.Println("                  named after the folder and saved in it. With -outdir the workbooks")
+	fmt.Println("                  mirror the folder tree (sales/2024 gives sales/2024.xlsx)")
+	fmt.Println("  -fail-fast      With -d or an archive, stops at the first file that fails to convert")
+	fmt.Println("                  instead of converting the others (with -s or an archive the workbook")
+	fmt.Println("                  is then not written) and exits with status 1")
+	fmt.Println("  -names file     With -s, reads sheet names from a CSV file of sourcefile,sheetname rows,")
+	fmt.Println("                  where sourcefile is the file name of a CSV or its path relative to the")
+	fmt.Println("                  directory (e.g. sales/jan.csv); the names are sanitized and cut to 31")
+	fmt.Println("                  characters, other files keep the usual names and entries matching no")
+	fmt.Println("                  file are reported")
+	fmt.Println("  -qualify-names  With -s, names each sheet after the path of the CSV relative to the")
+	fmt.Println("                  directory (sales/jan.csv becomes sales_jan) instead of the file name")
+	fmt.Println("                  alone; leading directories are dropped to fit the 31-character limit")
+	fmt.Println("                  and names still colliding get a numeric suffix")
+	fmt.Println("  -color-by-folder")
+	fmt.Println("                  With -s, colors the tabs of the sheets by the folder of their CSV,")
+	fmt.Println("                  so the sheets of a folder share a color (useful with -r). The color")
+	fmt.Println("                  is picked from a palette of 12 by the folder path, so it is the same")
+	fmt.Println("                  on every run; different folders may still share one")
+	fmt.Println("  -sort-sheets order")
+	fmt.Println("                  With -s, orders the sheets by CSV file name (name) or by CSV path")
+	fmt.Println("                  relative to the directory (path), ignoring case, instead of the")
+	fmt.Println("                  scan order (none, the default); -index lists them in that order")
+	fmt.Println("  -active name    With -s, opens the workbook on the sheet with this name or created from")
+	fmt.Println("                  this CSV file (e.g. totals.csv, totals or sales/totals.csv) instead")
+	fmt.Println("                  of the first one; the run fails, listing the sheets, when none matches")
+	fmt.Println("  -index          With -s, adds a first sheet named Index listing every data sheet")
+	fmt.Println("                  (with a link to it), its source file and its number of rows")
+	fmt.Println("  -sep char       Field separator, e.g. , ; | or \\t for tab (default: auto-detect)")
+	fmt.Println("  -sep-literal s  Field separator of several characters, e.g. || or ~|~, for legacy")
+	fmt.Println("                  exports. Lines are split at every occurrence as with -raw: quoting is")
+	fmt.Println("                  not supported, quotes are kept and a quoted separator splits the field")
+	fmt.Println("  -sep-regex re   Field separator given as a regular expression, e.g. \\s*\\|\\s* for a")
+	fmt.Println("                  pipe with optional spaces around it; lines are split at every match")
+	fmt.Println("                  as with -sep-literal, without quoting support")
+	fmt.Println("  -comment char   Skips lines starting with char (e.g. #); it must differ from the")
+	fmt.Println("                  separator. By default no lines are treated as comments")
+	fmt.Println("  -encoding name  Input encoding of files without BOM: utf8 (default), latin1 or")
+	fmt.Println("                  windows1252")
+	fmt.Println("  -strict-utf8    Fails the file on invalid UTF-8, naming its line, instead of")
+	fmt.Println("                  replacing the malformed bytes with the replacement character U+FFFD")
+	fmt.Println("  -no-typing      Stores every value as text; by default numeric values are stored as")
+	fmt.Println("                  numbers, except values with leading zeros (e.g. 007) and integers")
+	fmt.Println("                  longer than 15 digits, which are kept as text to avoid data loss")
+	fmt.Println("  -decimal comma  Reads numbers written with a decimal comma and dot thousands separators")
+	fmt.Println("                  (e.g. 1.234,56 or 12,5) and displays them with digit grouping; values")
+	fmt.Println("                  with a decimal point are then kept as text. Default: point")
+	fmt.Println("  -num-format fmt Excel number format (e.g. #,##0.00 for thousands separators and two")
+	fmt.Println("                  decimals) of the columns whose values are all numbers, apart from the")
+	fmt.Println("                  header; other columns are left untouched. It has no effect with")
+	fmt.Println("                  -no-typing, which stores no value as a number")
+	fmt.Println("  -float-decimals N")
+	fmt.Println("                  Displays the columns whose values are all numbers, at least one with")
+	fmt.Println("                  a fractional part (e.g. 2.5), with N decimals (format 0.00 for 2);")
+	fmt.Println("                  integer columns keep the default display. -num-format wins when both")
+	fmt.Println("                  are set, and neither has an effect with -no-typing")
+	fmt.Println("  -hyperlinks     Stores values consisting only of an http:// or https:// URL as")
+	fmt.Println("                  clickable links (blue, underlined) showing the URL; such values are")
+	fmt.Println("                  never converted to numbers or dates. Excel allows 65530 links per")
+	fmt.Println("                  sheet, further URLs are kept as text")
+	fmt.Println("  -dates          Converts values matching one of the -date-format layouts into dates")
+	fmt.Println("  -date-format list")
+	fmt.Println("                  Comma-separated Go layouts tried in order (default")
+	fmt.Println("                  2006-01-02,02/01/2006,2006-01-02 15:04:05, i.e. 2024-01-15 and 15/01/2024)")
+	fmt.Println("  -date-out fmt   Excel number format for converted dates (default yyyy-mm-dd)")
+	fmt.Println("  -types list     Forces the cell type of some columns, overriding the detection, as")
+	fmt.Println("                  comma-separated COLUMN=TYPE entries with the types text, number and")
+	fmt.Println("                  date (e.g. A=text,B=number,C=date). Text columns are never converted;")
+	fmt.Println("                  number columns convert every numeric value, leading zeros included;")
+	fmt.Println("                  date columns use the -date-format layouts, even without -dates.")
+	fmt.Println("                  Values that do not parse stay text; other columns are detected as")
+	fmt.Println("                  usual, or kept as text with -no-typing")
+	fmt.Println("  -updatedcell A1 Writes a bold \"last updated\" timestamp into the given cell;")
+	fmt.Println("                  the rows up to that cell are reserved and frozen, data starts below")
+	fmt.Println("  -updatedfmt fmt Excel number format for the timestamp (default yyyy-mm-dd hh:mm:ss)")
+	fmt.Println("  -durations      Converts columns containing only ISO-8601 durations to Excel time")
+	fmt.Println("                  values; supported syntax is PnW, PnD and TnHnMnS (e.g. PT1H30M,")
+	fmt.Println("                  P1DT2H, PT45.5S). Years and months are not supported")
+	fmt.Println("  -bools          Converts columns whose values are all boolean tokens (true/false or")
+	fmt.Println("                  yes/no by default, in any case) to Excel TRUE/FALSE values; empty")
+	fmt.Println("                  cells are allowed and a non-matching first row is kept as header.")
+	fmt.Println("                  Columns mixing tokens and other text are left as text")
+	fmt.Println("  -true-values list, -false-values list")
+	fmt.Println("                  Comma-separated tokens read as TRUE and FALSE by -bools (default")
+	fmt.Println("                  true,yes and false,no), e.g. -true-values y,1 -false-values n,0")
+	fmt.Println("  -currency       Converts columns whose values all use the same currency symbol ($, €,")
+	fmt.Println("                  £, ¥, ₹) and decimal style to numbers with a currency format")
+	fmt.Println("  -percent        Converts columns whose values all end with % (e.g. 12.5%, or 12,5% with")
+	fmt.Println("                  -decimal comma) to numbers displayed as percentages. The cell stores")
+	fmt.Println("                  the fraction, 100 times smaller (12.5% is stored as 0.125), so")
+	fmt.Println("                  formulas compute with it as Excel does with typed percentages")
+	fmt.Println("  -percent-format fmt")
+	fmt.Println("                  Excel number format of the -percent columns (default 0.0%, e.g. 0%")
+	fmt.Println("                  or 0.00%)")
+	fmt.Println("  -colorscale C   Converts the numeric values of column C (letter or number) to numbers")
+	fmt.Println("                  and applies a color scale conditional format to them, below the")
+	fmt.Println("                  header; it combines with -num-format and -currency")
+	fmt.Println("  -colorscale-colors list")
+	fmt.Println("                  Color scale colors from lowest to highest value, 2 or 3 of them")
+	fmt.Println("                  (default #F8696B,#FFEB84,#63BE7B: red, yellow, green)")
+	fmt.Println("  -heatmap        Applies the color scale to each column whose values below the header")
+	fmt.Println("                  are all numbers (empty cells allowed), each column scaled on its own;")
+	fmt.Println("                  text and empty columns are left alone. It combines with -num-format")
+	fmt.Println("                  and -float-decimals, which only change how the numbers are displayed")
+	fmt.Println("  -summarypanel C Writes COUNT, SUM and AVERAGE formulas over the numeric values of")
+	fmt.Println("                  column C in two frozen rows above the data")
+	fmt.Println("  -columns list   Writes only the listed CSV columns, in the listed order, as")
+	fmt.Println("                  comma-separated letters or numbers; COLUMN:Name also renames the")
+	fmt.Println("                  header (e.g. 3,1:Customer,E). The other column options (-colorscale,")
+	fmt.Println("                  -col-width, ...) refer to the columns of the sheet, not of the CSV")
+	fmt.Println("  -transpose      Swaps rows and columns: record i of the CSV fills column i, its field")
+	fmt.Println("                  j going to row j, e.g. for narrow and tall exports. The whole file is")
+	fmt.Println("                  read in memory first (not with -stream) and a sheet holds at most")
+	fmt.Println("                  16384 columns. The other options refer to the transposed rows and")
+	fmt.Println("                  columns (e.g. the header is the first CSV column)")
+	fmt.Println("  -min-width N, -max-width N")
+	fmt.Println("                  Limits of the automatic column widths (default 8 and 100)")
+	fmt.Println("  -width-factor F Automatic column width per character of the longest value")
+	fmt.Println("                  (default 1.2)")
+	fmt.Println("  -fixed-width N  Gives every used column the width N instead of sizing it to its")
+	fmt.Println("                  content, which also saves measuring every value of large files")
+	fmt.Println("                  (-col-width still sets single columns; not with -wrap)")
+	fmt.Println("  -smart-width    Counts East Asian wide and fullwidth characters (e.g. CJK) as two")
+	fmt.Println("                  characters in the automatic column widths, as they take two cells")
+	fmt.Println("  -col-width list Fixed widths for some columns, overriding the automatic ones, as")
+	fmt.Println("                  comma-separated COLUMN=WIDTH entries (e.g. A=20,C=50)")
+	fmt.Println("  -wrap           Wraps the text of the columns whose longest value needs more than")
+	fmt.Println("                  -max-width: they keep the maximum width and Excel grows the row")
+	fmt.Println("                  heights instead (columns set with -col-width are not wrapped)")
+	fmt.Println("  -wrap-lines     Wraps the text of the cells holding line breaks (quoted multiline")
+	fmt.Println("                  fields), which Excel otherwise shows on one line. Line breaks are")
+	fmt.Println("                  always kept and the column width fits the longest line of a value")
+	fmt.Println("  -font Arial     Font family of the cells written to the data sheets, instead of the")
+	fmt.Println("                  workbook default (Calibri); the family must be installed where the")
+	fmt.Println("                  workbook is opened")
+	fmt.Println("  -font-size 10   Font size of those cells in points, from 1 to 409 (default 11). Both")
+	fmt.Println("                  keep the rest of each cell's style: bold header, number formats,")
+	fmt.Println("                  fills and links. Column widths are not scaled: see -width-factor")
+	fmt.Println("  -align-by-type  Right-aligns the columns holding mostly numbers or dates and")
+	fmt.Println("                  left-aligns those holding mostly text, whatever the number format")
+	fmt.Println("                  or fill; the first row is treated as the header and keeps the")
+	fmt.Println("                  default alignment unless -skipheader is set")
+	fmt.Println("  -zebra          Shades every other data row with a light gray fill; the first row is")
+	fmt.Println("                  treated as the header and left unshaded unless -skipheader is set")
+	fmt.Println("  -groupstripe C  Shades the rows of each group of consecutive equal values in key")
+	fmt.Println("                  column C, alternating two fill colors per group; the first row is")
+	fmt.Println("                  treated as the header unless -skipheader is set")
+	fmt.Println("  -header mode    Bolds the first row of each sheet and freezes it, so it stays visible")
+	fmt.Println("                  while scrolling: always, never (default) or auto, which does so only")
+	fmt.Println("                  when every value of the first row is non-empty text and the second")
+	fmt.Println("                  row has a number below at least one of them. auto misses tables of")
+	fmt.Println("                  text only and numeric headers such as years; use always or never")
+	fmt.Println("                  for those. The first row is data with -skipheader, a header with")
+	fmt.Println("                  -add-header, and rows appended with -appendto are never styled")
+	fmt.Println("  -freeze-cols N  Freezes the leftmost N columns (e.g. a key in column A) so they stay")
+	fmt.Println("                  visible while scrolling right, together with the header of -header")
+	fmt.Println("                  and the rows frozen above the data by -updatedcell and")
+	fmt.Println("                  -summarypanel; N cannot exceed the columns of a sheet")
+	fmt.Println("  -keep-default-sheet")
+	fmt.Println("                  Keeps the empty default sheet (Sheet1), e.g. for notes; the data")
+	fmt.Println("                  sheet is still the active one")
+	fmt.Println("  -autofilter     Enables Excel's autofilter dropdowns on the header row")
+	fmt.Println("  -table          Formats the data as an Excel table (TableStyleMedium2) with the first")
+	fmt.Println("                  row as header; empty or repeated header cells are named ColumnN")
+	fmt.Println("  -headeronly     Writes only the first row of each CSV as a bold header (empty template)")
+	fmt.Println("  -append-to workbook.xlsx")
+	fmt.Println("                  Adds the converted CSVs as new sheets of an existing workbook and saves")
+	fmt.Println("                  it in place, instead of creating a new file: the CSV of -f, or every")
+	fmt.Println("                  CSV of -d (as with -s, which it implies). Sheets already in the")
+	fmt.Println("                  workbook are kept, and new names colliding with them get a numeric")
+	fmt.Println("                  suffix. Not available with -o, -outdir, -stream or -rows-per-sheet")
+	fmt.Println("  -appendto workbook.xlsx:Sheet")
+	fmt.Println("                  With -f, appends the CSV rows below the last used row of the given")
+	fmt.Println("                  sheet of an existing workbook (the sheet is created if missing).")
+	fmt.Println("                  Not available with -updatedcell, -summarypanel, -autofilter or -table")
+	fmt.Println("  -skip-lines N   Discards the first N CSV records (e.g. metadata lines above the real")
+	fmt.Println("                  header), so the data starts at the first row of the sheet")
+	fmt.Println("  -max-rows N     Stops after writing N data rows below the header, e.g. to sample a")
+	fmt.Println("                  large file; -skip-lines records and skipped rows do not count")
+	fmt.Println("  -max-cell-len N Truncates the values longer than N characters (default 32767, the most")
+	fmt.Println("                  an Excel cell holds) to N, the last one being …, with a warning naming")
+	fmt.Println("                  the cell; the number of long values is added to the file's summary")
+	fmt.Println("  -split-long-cells")
+	fmt.Println("                  Keeps the whole of the values over -max-cell-len by spilling the rest")
+	fmt.Println("                  into the next cells of the row, which moves the following fields of")
+	fmt.Println("                  that row to the right")
+	fmt.Println("  -rows-per-sheet N")
+	fmt.Println("                  Splits each CSV over sheets named after it with a _1, _2, ... suffix,")
+	fmt.Println("                  each holding at most N data rows below a copy of the header (unless")
+	fmt.Println("                  -skipheader is set); the data rows written to each sheet are listed.")
+	fmt.Println("                  Not available with -s, -appendto or archives")
+	fmt.Println("  -skipheader     Does not write the first CSV row")
+	fmt.Println("  -add-header list")
+	fmt.Println("                  Writes the comma-separated titles (quoted like CSV fields when they")
+	fmt.Println("                  contain commas) as the header of headerless CSVs, above the first")
+	fmt.Println("                  row; with -skipheader they replace the CSV header instead. A row")
+	fmt.Println("                  with more fields than titles is an error. -columns and the other")
+	fmt.Println("                  options treat the titles as the CSV header")
+	fmt.Println("  -skip-empty     Does not write rows whose fields are all empty (e.g. ;;;), so no gap")
+	fmt.Println("                  is left in the sheet; the first non-empty row is the header")
+	fmt.Println("  -skip-empty-files")
+	fmt.Println("                  Skips the CSV files without data rows (empty, or holding only the")
+	fmt.Println("                  header) instead of writing a sheet marked (empty): no file is")
+	fmt.Println("                  written for them, their sheet is left out in -s mode, and they are")
+	fmt.Println("                  counted apart in the summary")
+	fmt.Println("  -pad            Writes empty cells after the last field of rows shorter than the widest")
+	fmt.Println("                  row of the CSV, so every row spans the same columns")
+	fmt.Println("  -trim           Removes the spaces and tabs around every value (e.g. padded fixed-width")
+	fmt.Println("                  exports), so numbers are detected and columns fit the real content;")
+	fmt.Println("                  by default whitespace is kept as is")
+	fmt.Println("  -keep-quotes    Keeps the quotes that remain at the start or end of a value once the")
+	fmt.Println("                  CSV quoting is decoded, e.g. \"He said \"\"hi\"\"\" becomes He said \"hi\"")
+	fmt.Println("                  instead of He said \"hi")
+	fmt.Println("  -raw            Splits every line on the separator without decoding the CSV quoting,")
+	fmt.Println("                  so the text between two separators lands in the cell byte for byte,")
+	fmt.Println("                  quotes included (e.g. 5\" pipe;\"open stays 5\" pipe and \"open); for")
+	fmt.Println("                  exports that are not valid CSV. A separator inside quotes splits the")
+	fmt.Println("                  field and values are not trimmed of spaces")
+	fmt.Println("  -stream         Writes rows straight to the XLSX file instead of building the sheet")
+	fmt.Println("                  in memory, for very large CSVs; column widths are estimated from the")
+	fmt.Println("                  first 1000 rows. Not available with -s, -appendto, archives or the")
+	fmt.Println("                  column post-processing options (-durations, -currency, -percent,")
+	fmt.Println("                  -colorscale, -summarypanel, -groupstripe, -zebra, -wrap, -updatedcell,")
+	fmt.Println("                  -autofilter, -table, -headeronly, -pad, -rows-per-sheet,")
+	fmt.Println("                  -num-format, -float-decimals, -hyperlinks, -bools, -align-by-type,")
+	fmt.Println("                  -transpose)")
+	fmt.Println("  -log path.log   Appends a timestamped entry for each file processed to this file:")
+	fmt.Println("                  source, output (and sheet), rows, columns, time spent and the error")
+	fmt.Println("                  of failed files, plus the start, end and totals of the run. Each")
+	fmt.Println("                  entry is written at once, so the log is up to date while -watch")
+	fmt.Println("                  runs; the console output is unchanged")
+	fmt.Println("  -metricsfile path")
+	fmt.Println("                  Writes files converted/failed, rows, bytes read and duration of the")
+	fmt.Println("                  run in Prometheus textfile format (for the node exporter)")
+	fmt.Println("  -json           Prints a JSON report to standard output at the end of the run instead")
+	fmt.Println("                  of the usual messages: source, output, sheet, rows, columns and error")
+	fmt.Println("                  of each file, plus the run totals (errors are still written to")
+	fmt.Println("                  standard error). Not available with -v")
+	fmt.Println("  -config file    Reads flag values from a JSON object mapping flag names (without the")
+	fmt.Println("                  dash) to values, e.g. {\"sep\": \";\", \"zebra\": true, \"exclude\":")
+	fmt.Println("                  [\"*_bak.csv\"]}; flags given on the command line override the file")
+	fmt.Println("                  and unknown keys are reported and ignored")
+	fmt.Println("  -preset name    Turns on a bundle of options: report sets -header always,")
+	fmt.Println("                  -autofilter, -zebra and -smart-width, for a workbook ready to read;")
+	fmt.Println("                  plain sets nothing (the bare output). Flags given on the command")
+	fmt.Println("                  line or in the -config file override the preset, e.g. -preset report")
+	fmt.Println("                  -zebra=false")
+	fmt.Println("  -q              Quiet output: prints only errors, warnings and the final summary")
+	fmt.Println("  -v              Verbose output: also reports the encoding and separator detected and")
+	fmt.Println("                  the rows and columns written for each sheet")
+	fmt.Println("  -h, --help      Shows this help message")
+	fmt.Println("  -version        Prints the tool version and the Go and excelize versions it was built")
+	fmt.Println("                  with, for bug reports (the tool version is dev unless the build sets it)")
+	fmt.Println("\nExamples:")
+	fmt.Println("  csvtoxls -f data.csv                   # Converts a single file")
+	fmt.Println("  csvtoxls -d ./data                     # Converts all CSVs to separate files")
+	fmt.Println("  csvtoxls -d ./data -s                  # Converts all CSVs to a single Excel file")
+	fmt.Println("  csvtoxls -d ./data -r                  # Also converts CSVs in subdirectories")
+	fmt.Println("  csvtoxls -f data.csv -o out/report     # Writes out/report.xlsx")
+	fmt.Println("  cat data.csv | csvtoxls -f - -o out.xlsx")
+	fmt.Println("                                         # Converts standard input")
+	fmt.Println("  csvtoxls -f data.csv -sep ,            # Converts a comma-separated file")
+	fmt.Println("  csvtoxls -f report.xlsx -reverse       # Converts each sheet back to CSV")
+	fmt.Println("  csvtoxls -f data.csv -updatedcell A1   # Adds a timestamp above the data")
+	fmt.Println("  csvtoxls -f huge.csv -stream           # Converts a large file with low memory use")
+	fmt.Println("  csvtoxls -d ./data -json > report.json # Saves a machine-readable report of the run")
+	fmt.Println("  csvtoxls -f jan.csv -appendto report.xlsx:Data -skipheader")
+	fmt.Println("                                         # Appends rows to an existing sheet")
+	fmt.Println("\nNotes:")
+	fmt.Println("  - Without -sep the separator is detected from the first 10 non-empty lines")
+	fmt.Println("    (; , tab or |), falling back to semicolon (;) when there is no clear winner")
+	fmt.Println("  - Input is read as UTF-8 unless -encoding says otherwise; a UTF-8 BOM is stripped")
+	fmt.Println("    and files starting with a UTF-16 LE/BE BOM (Windows \"Unicode\" exports) are")
+	fmt.Println("    decoded automatically, regardless of -encoding")
+	fmt.Println("  - Values with leading zeros (e.g. 00123) are always stored as text with the")
+	fmt.Println("    Text (@) number format")
+	fmt.Println("  - Quotes are removed from values: after the CSV quoting is decoded, one quote left at")
+	fmt.Println("    the start and one at the end of a value are dropped, unless -keep-quotes or -raw is set")
+	fmt.Println("  - Column widths are automatically adjusted to fit content (see -width-factor)")
+	fmt.Println("  - With -durations a non-matching first row is treated as a header and kept as text")
+	fmt.Println("  - Existing files will be overwritten without warning, unless -no-clobber is set")
+	fmt.Println("  - Errors are written to standard error. The exit status is 0 on success, 2 when")
+	fmt.Println("    only some files of a directory or archive failed and 1 for any other failure")
+	fmt.Println("  - Ctrl-C stops the run without writing the file being converted (files already")
+	fmt.Println("    converted are kept) and exits with status 130; with -watch it exits with status 0")
+}
+
+// Process a single CSV file
+func processFile(ctx context.Context, csvFilePath string, opts options) (err error) {
+	// Count the file in the run metrics
+	metrics.begin()
+	var xlsxFilePath string
+	var stats csvxls.Stats
+	opts.Stats = &stats
+	defer func() {
+		metrics.record(csvFilePath, xlsxFilePath, "", stats, err)
+	}()
+
+	if csvFilePath == csvxls.StdinPath {
+		// Standard input has no name to derive the output from
+		if opts.outputPath == "" && !opts.stdout {
+			return fmt.Errorf("reading from standard input requires -o or -stdout")
+		}
+	} else {
+		// Verify that the file exists
+		if _, err := os.Stat(csvFilePath); os.IsNotExist(err) {
+			return fmt.Errorf("file %s does not exist", csvFilePath)
+		}
+
+		// Verify that the file has a supported extension
+		if !isCSVFile(csvFilePath, opts) {
+			if strings.EqualFold(filepath.Ext(csvFilePath), ".txt") {
+				return fmt.Errorf("file %s needs -sep, -sep-literal or -sep-regex to be converted as a delimited text file", csvFilePath)
+			}
+			return fmt.Errorf("file %s is not a CSV file", csvFilePath)
+		}
+	}
+
+	// Stream the workbook instead of saving it
+	if opts.stdout {
+		xlsxFilePath = csvxls.StdoutPath
+		if err := csvxls.ConvertFile(ctx, csvFilePath, xlsxFilePath, opts.Options); err != nil {
+			return err
+		}
+		opts.infof("Conversion completed: %s -> standard output (%d rows, %d columns%s)", csvFilePath, stats.Rows, stats.Columns, longSummary(stats, opts))
+		return nil
+	}
+
+	// Create name for the Excel file
+	xlsxFilePath = csvxls.TrimCSVExt(csvFilePath) + opts.ext
+	if opts.outputPath != "" {
+		if xlsxFilePath, err = prepareOutputPath(opts.outputPath, opts.ext); err != nil {
+			return err
+		}
+	}
+
+	// Skip the files converted by a previous -incremental run
+	if opts.cache != nil && opts.cache.unchanged(csvFilePath, xlsxFilePath) {
+		return errUnchanged
+	}
+
+	// Keep the workbooks that already exist with -no-clobber
+	if err := opts.checkClobber(xlsxFilePath); err != nil {
+		return err
+	}
+
+	// Convert the CSV content to a new workbook
+	if err := csvxls.ConvertFile(ctx, csvFilePath, xlsxFilePath, opts.Options); err != nil {
+		if opts.cache != nil {
+			opts.cache.forget(csvFilePath)
+		}
+		return err
+	}
+	if opts.cache != nil {
+		opts.cache.update(csvFilePath, xlsxFilePath)
+	}
+
+	opts.infof("Conversion completed: %s -> %s (%d rows, %d columns%s)", csvFilePath, xlsxFilePath, stats.Rows, stats.Columns, longSummary(stats, opts))
+	return nil
+}
+
+// Process all CSV files in a directory (separate files)
+func processDirectory(ctx context.Context, dirPath string, opts options) error {
+	// Verify that the directory exists
+	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+		return fmt.Errorf("directory %s does not exist", dirPath)
+	}
+
+	// Collect all CSV files first, so progress can be reported
+	csvFiles, excludedCount, err := collectCSVFiles(dirPath, opts)
+	if err != nil {
+		return err
+	}
+
+	// Counters for statistics
+	var successCount, failCount, skippedCount, unchangedCount, existingCount int
+
+	// Remember the conversions for the next -incremental run
+	if opts.incremental {
+		opts.cache = loadConversionCache(dirPath)
+		defer opts.cache.save()
+	}
+
+	// Output paths already used in this run (to avoid collisions in -outdir)
+	usedOutputs := make(map[string]bool)
+
+	for i, path := range csvFiles {
+		// Stop before the next file once canceled
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fileOpts := opts
+		if opts.outputDir != "" {
+			fileOpts.outputPath = outputPathInDir(dirPath, path, opts, usedOutputs)
+		}
+
+		err := processFile(ctx, path, fileOpts)
+		if errors.Is(err, csvxls.ErrNoData) {
+			opts.infof("Skipped %s: it has no data rows", path)
+			skippedCount++
+			err = nil
+		} else if errors.Is(err, errUnchanged) {
+			opts.infof("Skipped %s: it did not change since its last conversion", path)
+			unchangedCount++
+			err = nil
+		} else if errors.Is(err, errExists) {
+			fmt.Fprintf(os.Stderr, "Warning: skipped %s: %v\n", path, err)
+			existingCount++
+			err = nil
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			failCount++
+		} else {
+			successCount++
+		}
+		opts.progressf(i+1, len(csvFiles), path)
+
+		if err != nil && opts.failFast {
+			opts.summaryf("\nSummary: aborted after %d files successfully converted and 1 failed", successCount)
+			return errFailFast
+		}
+	}
+
+	// Print statistics
+	opts.summaryf("\nSummary: %d files successfully converted (%d rows), %d failed, %d excluded%s%s%s", successCount, metrics.convertedRows(), failCount, excludedCount, skippedSummary(skippedCount), unchangedSummary(unchangedCount), existingSummary(existingCount))
+
+	if successCount == 0 && failCount == 0 && skippedCount == 0 && unchangedCount == 0 && existingCount == 0 {
+		opts.summaryf("No CSV files found in the directory")
+	}
+
+	if failCount > 0 {
+		return &batchError{failed: failCount, total: successCount + failCount}
+	}
+	return nil
+}
+
+// Maximum number of problems listed for each file by -validate
+const maxValidationProblems = 10
+
+// Check that a CSV file parses with a consistent number of fields, printing
+// whether it passed and the problems found
+func validateFile(ctx context.Context, csvFilePath string, opts options) (err error) {
+	// Count the file in the run metrics
+	metrics.begin()
+	var stats csvxls.Stats
+	opts.Stats = &stats
+	defer func() {
+		metrics.record(csvFilePath, "", "", stats, err)
+	}()
+
+	var r io.Reader = os.Stdin
+	if csvFilePath != csvxls.StdinPath {
+		if !isCSVFile(csvFilePath, opts) {
+			return fmt.Errorf("file %s is not a CSV file", csvFilePath)
+		}
+		csvFile, err := csvxls.OpenCSV(csvFilePath)
+		if err != nil {
+			return fmt.Errorf("unable to open CSV file %s: %v", csvFilePath, err)
+		}
+		defer csvFile.Close()
+		r = csvFile
+
+		if opts.Separator == 0 {
+			opts.Separator = csvxls.DefaultSeparator(csvFilePath)
+		}
+	}
+
+	problems, err := csvxls.Validate(ctx, r, opts.Options)
+	if err != nil {
+		return fmt.Errorf("validation failed for %s: %v", csvFilePath, err)
+	}
+	if len(problems) == 0 {
+		opts.summaryf("PASS %s (%d rows, %d columns)", csvFilePath, stats.Rows, stats.Columns)
+		return nil
+	}
+
+	opts.summaryf("FAIL %s", csvFilePath)
+	for i, problem := range problems {
+		if i == maxValidationProblems {
+			opts.summaryf("  ... and %d more", len(problems)-i)
+			break
+		}
+		opts.summaryf("  %s", problem)
+	}
+	return fmt.Errorf("%s is not valid: %d problems, the first at %s", csvFilePath, len(problems), problems[0])
+}
+
+// Check all CSV files in a directory without writing any workbook
+func validateDirectory(ctx context.Context, dirPath string, opts options) error {
+	// Verify that the directory exists
+	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+		return fmt.Errorf("directory %s does not exist", dirPath)
+	}
+
+	csvFiles, excludedCount, err := collectCSVFiles(dirPath, opts)
+	if err != nil {
+		return err
+	}
+
+	var validCount, invalidCount int
+	for i, csvFilePath := range csvFiles {
+		// Stop before the next file once canceled
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := validateFile(ctx, csvFilePath, opts)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			invalidCount++
+		} else {
+			validCount++
+		}
+		opts.progressf(i+1, len(csvFiles), csvFilePath)
+
+		if err != nil && opts.failFast {
+			opts.summaryf("\nSummary: aborted after %d files valid and 1 invalid", validCount)
+			return errFailFast
+		}
+	}
+
+	// Print statistics
+	opts.summaryf("\nSummary: %d files valid, %d invalid, %d excluded", validCount, invalidCount, excludedCount)
+	if len(csvFiles) == 0 {
+		opts.summaryf("No CSV files found in the directory")
+	}
+
+	if invalidCount > 0 {
+		return &batchError{failed: invalidCount, total: validCount + invalidCount}
+	}
+	return nil
+}
+
+// Read a -list manifest of CSV paths, one per line, relative to the
+// directory of the manifest unless absolute; blank lines and lines
+// starting with # are ignored
+func loadFileList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(path), line)
+		}
+		files = append(files, line)
+	}
+	return files, nil
+}
+
+// Return the base name of the workbook combining the CSV files of a
+// directory run: the directory name, or the manifest name with -list
+func combinedName(dirPath string, opts options) string {
+	if opts.listPath != "" {
+		return strings.TrimSuffix(filepath.Base(opts.listPath), filepath.Ext(opts.listPath))
+	}
+
+	// The absolute path names . and .. after the actual folder
+	return filepath.Base(absPath(dirPath))
+}
+
+// Collect the CSV files of a directory (and its subdirectories when
+// recursive) that are not excluded, also returning the number excluded,
+// or the files of the -list manifest
+func collectCSVFiles(dirPath string, opts options) ([]string, int, error) {
+	// The manifest gives the files and their order
+	if opts.listPath != "" {
+		return slices.Clone(opts.listFiles), 0, nil
+	}
+
+	// The files of a -per-dir folder were collected with the whole tree
+	if opts.folderFiles != nil {
+		return slices.Clone(opts.folderFiles), 0, nil
+	}
+
+	var csvFiles []string
+	excludedCount := 0
+	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip directories, not descending into subdirectories unless recursive
+		if d.IsDir() {
+			if path != dirPath && !opts.recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Collect only CSV files, skipping excluded ones
+		if isCSVFile(path, opts) {
+			if isExcluded(path, opts.excludes) {
+				excludedCount++
+				metrics.filesExcluded++
+				return nil
+			}
+			csvFiles = append(csvFiles, path)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, excludedCount, fmt.Errorf("error scanning directory: %v", err)
+	}
+	return csvFiles, excludedCount, nil
+}
+
+// Order CSV files by file name (name) or by path (path), ignoring case and
+// keeping the scan order for ties; none keeps the scan order
+func sortCSVFiles(csvFiles []string, order string) {
+	switch order {
+	case "name":
+		slices.SortStableFunc(csvFiles, func(a, b string) int {
+			return strings.Compare(strings.ToLower(filepath.Base(a)), strings.ToLower(filepath.Base(b)))
+		})
+	case "path":
+		slices.SortStableFunc(csvFiles, func(a, b string) int {
+			return strings.Compare(strings.ToLower(filepath.ToSlash(a)), strings.ToLower(filepath.ToSlash(b)))
+		})
+	}
+}
+
+// Interval between two scans of the directory in watch mode
+const watchInterval = time.Second
+
+// Size and modification time of a CSV file seen in watch mode
+type watchedFile struct {
+	modTime time.Time
+	size    int64
+	pending bool // Changed since the last conversion, waiting for the writes to settle
+}
+
+// Watch a directory and convert the CSV files created or modified in it
+// until ctx is canceled. The directory is scanned every watchInterval and a
+// changed file is converted once a scan finds it unchanged, so a file still
+// being written is not converted halfway
+func processWatch(ctx context.Context, dirPath string, opts options) error {
+	// Verify that the directory exists
+	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+		return fmt.Errorf("directory %s does not exist", dirPath)
+	}
+
+	files := make(map[string]watchedFile)
+
+	// Output paths already assigned in -outdir mode, kept across conversions
+	usedOutputs := make(map[string]bool)
+	outputs := make(map[string]string)
+
+	scan := func(initial bool) error {
+		seen := make(map[string]bool)
+		err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Skip directories, not descending into subdirectories unless recursive
+			if d.IsDir() {
+				if path != dirPath && !opts.recursive {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !isCSVFile(path, opts) || isExcluded(path, opts.excludes) {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				// Removed since the directory was read
+				return nil
+			}
+			seen[path] = true
+
+			// The files present at startup are the baseline
+			previous, known := files[path]
+			current := watchedFile{modTime: info.ModTime(), size: info.Size()}
+			switch {
+			case initial:
+				files[path] = current
+			case !known || !current.modTime.Equal(previous.modTime) || current.size != previous.size:
+				current.pending = true
+				files[path] = current
+			case previous.pending:
+				files[path] = current
+
+				fileOpts := opts
+				if opts.outputDir != "" {
+					if outputs[path] == "" {
+						outputs[path] = outputPathInDir(dirPath, path, opts, usedOutputs)
+					}
+					fileOpts.outputPath = outputs[path]
+				}
+				if err := processFile(ctx, path, fileOpts); errors.Is(err, csvxls.ErrNoData) {
+					opts.infof("Skipped %s: it has no data rows", path)
+				} else if errors.Is(err, errExists) {
+					fmt.Fprintf(os.Stderr, "Warning: skipped %s: %v\n", path, err)
+				} else if err != nil && ctx.Err() == nil {
+					fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				}
+			}
+			return ctx.Err()
+		})
+
+		// Forget the removed files, so they count as new if they come back
+		for path := range files {
+			if !seen[path] {
+				delete(files, path)
+			}
+		}
+		return err
+	}
+
+	if err := scan(true); err != nil {
+		return fmt.Errorf("error scanning directory: %v", err)
+	}
+	opts.infof("Watching %s for new or modified CSV files (Ctrl-C to stop)", dirPath)
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	// Ctrl-C is the normal way to stop watching, not a failure
+	for {
+		select {
+		case <-ctx.Done():
+			opts.summaryf("Stopped watching %s", dirPath)
+			return nil
+		case <-ticker.C:
+			if err := scan(false); err != nil {
+				if ctx.Err() != nil {
+					opts.summaryf("Stopped watching %s; the file being converted was not written", dirPath)
+					return nil
+				}
+				fmt.Fprintf(os.Stderr, "ERROR: error scanning directory: %v\n", err)
+			}
+		}
+	}
+}
+
+// Process all CSV files in a directory (single file with multiple sheets)
+func processDirectoryToSingleFile(ctx context.Context, dirPath string, opts options) error {
+	// Verify that the directory exists
+	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+		return fmt.Errorf("directory %s does not exist", dirPath)
+	}
+
+	// Name of the output Excel file
+	dirName := combinedName(dirPath, opts)
+	xlsxFilePath := filepath.Join(dirPath, dirName+opts.ext)
+	if opts.outputDir != "" {
+		var err error
+		if xlsxFilePath, err = prepareOutputPath(filepath.Join(opts.outputDir, dirName+opts.ext), opts.ext); err != nil {
+			return err
+		}
+	}
+
+	// Keep the workbook if it already exists with -no-clobber
+	if err := opts.checkClobber(xlsxFilePath); err != nil && opts.workbook == "" {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %s: %v\n", dirPath, err)
+		return nil
+	}
+
+	// Create a new Excel file, or open the existing one with -append-to,
+	// whose sheets are all kept
+	var f *excelize.File
+	var defaultSheet string
+	if opts.workbook != "" {
+		xlsxFilePath = opts.workbook
+		var err error
+		if f, err = openWorkbook(xlsxFilePath, opts); err != nil {
+			return err
+		}
+		defer f.Close()
+	} else {
+		f = excelize.NewFile()
+		defer f.Close()
+
+		// Get the default sheet name
+		defaultSheet = f.GetSheetName(0) // Usually "Sheet1"
+	}
+
+	// Counters for statistics
+	var successCount, failCount, skippedCount int
+	var firstSheet string
+
+	// Collect all CSV files
+	csvFiles, excludedCount, err := collectCSVFiles(dirPath, opts)
+	if err != nil {
+		return err
+	}
+	sortCSVFiles(csvFiles, opts.sortSheets)
+
+	// Check if there are CSV files
+	if len(csvFiles) == 0 {
+		opts.summaryf("No CSV files found in the directory")
+		return nil
+	}
+
+	// Keep the workbook when -incremental finds no CSV file changed
+	if opts.incremental {
+		opts.cache = loadConversionCache(dirPath)
+		if skipUnchangedAll(csvFiles, xlsxFilePath, opts) {
+			return nil
+		}
+	}
+
+	// Map to keep track of sheet names (to avoid duplicates), including
+	// those of an existing workbook and reserving the name of the index sheet
+	sheetNames := make(map[string]bool)
+	for _, name := range f.GetSheetList() {
+		sheetNames[strings.ToLower(name)] = true
+	}
+	if opts.index {
+		if sheetNames[strings.ToLower(indexSheetName)] {
+			return fmt.Errorf("workbook %s already has a sheet named %s", xlsxFilePath, indexSheetName)
+		}
+		sheetNames[strings.ToLower(indexSheetName)] = true
+	}
+
+	// Data sheets listed in the index sheet
+	var indexEntries []indexEntry
+
+	// CSV files converted or skipped as empty, recorded by -incremental
+	var converted []string
+
+	// Entries of the -names file that matched a CSV file
+	mappingsUsed := make(map[string]bool)
+
+	// Process all CSV files
+	for i, csvFilePath := range csvFiles {
+		// Stop before the next file once canceled
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Time the conversion of the file for -log
+		metrics.begin()
+
+		// Use the mapped name, or the file name (or relative path), as sheet
+		// name, avoiding duplicates
+		sheetName := csvxls.SheetNameFromFile(csvFilePath)
+		if mappedName, ok := lookupSheetName(dirPath, csvFilePath, opts.sheetMap, mappingsUsed); ok {
+			sheetName = csvxls.ValidSheetName(mappedName)
+		} else if opts.qualify {
+			sheetName = qualifiedSheetName(dirPath, csvFilePath)
+		}
+		sheetName = csvxls.UniqueSheetName(sheetName, sheetNames)
+
+		// Create a new sheet
+		_, err := f.NewSheet(sheetName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: Unable to create sheet %s: %v\n", sheetName, err)
+			failCount++
+			metrics.record(csvFilePath, xlsxFilePath, sheetName, csvxls.Stats{}, err)
+			opts.progressf(i+1, len(csvFiles), csvFilePath)
+			if opts.failFast {
+				break
+			}
+			continue
+		}
+
+		// Save the name of the first sheet to set it as active
+		if firstSheet == "" {
+			firstSheet = sheetName
+		}
+
+		// Convert the CSV content
+		var stats csvxls.Stats
+		fileOpts := opts
+		fileOpts.Stats = &stats
+		err = convertCSVtoSheet(ctx, csvFilePath, f, sheetName, 1, fileOpts)
+		metrics.record(csvFilePath, xlsxFilePath, sheetName, stats, err)
+		opts.progressf(i+1, len(csvFiles), csvFilePath)
+		if err != nil {
+			// Leave the sheet of an empty or failed file out, freeing its name
+			f.DeleteSheet(sheetName)
+			delete(sheetNames, strings.ToLower(sheetName))
+			if firstSheet == sheetName {
+				firstSheet = ""
+			}
+		}
+		if err == nil || errors.Is(err, csvxls.ErrNoData) {
+			converted = append(converted, csvFilePath)
+		}
+		if errors.Is(err, csvxls.ErrNoData) {
+			opts.infof("Skipped %s: it has no data rows", csvFilePath)
+			skippedCount++
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			failCount++
+			if opts.failFast {
+				break
+			}
+		} else {
+			opts.infof("Sheet '%s' created from %s (%d rows, %d columns%s)", sheetName, csvFilePath, stats.Rows, stats.Columns, longSummary(stats, opts))
+			successCount++
+			indexEntries = append(indexEntries, indexEntry{sheetName, csvFilePath, stats.Rows})
+
+			// Group the sheets visually by the folder of their CSV
+			if opts.colorByDir {
+				color := folderTabColor(dirPath, csvFilePath)
+				if err := f.SetSheetProps(sheetName, &excelize.SheetPropsOptions{TabColorRGB: &color}); err != nil {
+					return fmt.Errorf("error setting tab color of sheet %s: %v", sheetName, err)
+				}
+			}
+		}
+	}
+
+	// Leave the workbook unwritten when -fail-fast stopped the run
+	if opts.failFast && failCount > 0 {
+		opts.summaryf("\nSummary: aborted after %d sheets successfully created and 1 failed, %s not written", successCount, xlsxFilePath)
+		return errFailFast
+	}
+
+	// Report the mapped names that matched no file, most likely typos
+	for _, source := range slices.Sorted(maps.Keys(opts.sheetMap)) {
+		if !mappingsUsed[source] {
+			fmt.Fprintf(os.Stderr, "Warning: -names entry %s matched no CSV file\n", source)
+		}
+	}
+
+	// Put the index sheet first and show it on opening
+	if opts.index {
+		if err := writeIndexSheet(f, indexEntries); err != nil {
+			return err
+		}
+		if err := f.MoveSheet(indexSheetName, f.GetSheetName(0)); err != nil {
+			return fmt.Errorf("error moving the index sheet: %v", err)
+		}
+		firstSheet = indexSheetName
+	}
+
+	// The requested sheet replaces the first one
+	if opts.active != "" {
+		sheetName, err := findActiveSheet(dirPath, opts.active, indexEntries)
+		if err != nil {
+			return err
+		}
+		firstSheet = sheetName
+	}
+
+	// Set the first sheet as active (if it exists)
+	if firstSheet != "" {
+		index, _ := f.GetSheetIndex(firstSheet)
+		f.SetActiveSheet(index)
+
+		// Delete the default sheet of a new workbook after setting the active sheet
+		if defaultSheet != "" && !opts.KeepDefaultSheet {
+			f.DeleteSheet(defaultSheet)
+		}
+	}
+
+	// Describe the workbook, a new one titled after the directory
+	defaultTitle := dirName
+	if opts.workbook != "" {
+		defaultTitle = ""
+	}
+	if err := csvxls.SetProperties(f, defaultTitle, opts.Options); err != nil {
+		return err
+	}
+
+	// Save the Excel file atomically, so an interrupted or failed save
+	// never leaves a corrupt workbook
+	if err := csvxls.SaveAtomically(f, xlsxFilePath, excelize.Options{Password: opts.Password}); err != nil {
+		return err
+	}
+	if opts.workbook != "" {
+		opts.summaryf("\nExcel file updated: %s", xlsxFilePath)
+	} else {
+		opts.summaryf("\nExcel file created: %s", xlsxFilePath)
+	}
+
+	// The failed files are converted again by the next -incremental run
+	if opts.cache != nil {
+		opts.cache.updateAll(converted, xlsxFilePath)
+		opts.cache.save()
+	}
+
+	// Print statistics, counting the rows of this workbook only (-per-dir
+	// writes several in one run)
+	rows := 0
+	for _, entry := range indexEntries {
+		rows += entry.rows
+	}
+	opts.summaryf("Summary: %d sheets successfully created (%d rows), %d failed, %d excluded%s", successCount, rows, failCount, excludedCount, skippedSummary(skippedCount))
+
+	if failCount > 0 {
+		return &batchError{failed: failCount, total: successCount + failCount}
+	}
+	return nil
+}
+
+// Process the CSV files of a directory tree into one workbook per folder,
+// each combining the CSV files directly in that folder as -s does
+func processDirectoryPerFolder(ctx context.Context, dirPath string, opts options) error {
+	// Verify that the directory exists
+	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+		return fmt.Errorf("directory %s does not exist", dirPath)
+	}
+
+	// Group the CSV files by folder, in scan order
+	csvFiles, excludedCount, err := collectCSVFiles(dirPath, opts)
+	if err != nil {
+		return err
+	}
+	var folders []string
+	folderFiles := make(map[string][]string)
+	for _, csvFilePath := range csvFiles {
+		folder := filepath.Dir(csvFilePath)
+		if folderFiles[folder] == nil {
+			folders = append(folders, folder)
+		}
+		folderFiles[folder] = append(folderFiles[folder], csvFilePath)
+	}
+	if len(folders) == 0 {
+		opts.summaryf("No CSV files found in the directory")
+		return nil
+	}
+
+	failed := false
+	for _, folder := range folders {
+		// Stop before the next folder once canceled
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Mirror the subfolders under -outdir, where folders of the same
+		// name would otherwise write the same workbook
+		folderOpts := opts
+		folderOpts.folderFiles = folderFiles[folder]
+		if opts.outputDir != "" {
+			if relPath, err := filepath.Rel(dirPath, folder); err == nil && relPath != "." {
+				folderOpts.outputDir = filepath.Join(opts.outputDir, filepath.Dir(relPath))
+			}
+		}
+
+		// A folder with failed files does not stop the others
+		err := processDirectoryToSingleFile(ctx, folder, folderOpts)
+		var batchErr *batchError
+		if errors.As(err, &batchErr) {
+			failed = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// Print the totals of all the workbooks
+	opts.summaryf("\nTotal: %d workbooks, one per folder, %d sheets successfully created (%d rows), %d failed, %d excluded", len(folders), metrics.filesConverted, metrics.convertedRows(), metrics.filesFailed, excludedCount)
+
+	if failed {
+		return &batchError{failed: metrics.filesFailed, total: metrics.filesConverted + metrics.filesFailed}
+	}
+	return nil
+}
+
+// Return the data sheet matching the -active value: a sheet name (ignoring
+// case, as Excel does) or the file name of its CSV, with or without the
+// extension, or the CSV path relative to the directory
+func findActiveSheet(rootDir, active string, entries []indexEntry) (string, error) {
+	for _, entry := range entries {
+		relPath, _ := filepath.Rel(rootDir, entry.source)
+		base := filepath.Base(entry.source)
+		if strings.EqualFold(entry.sheetName, active) || active == base || active == csvxls.TrimCSVExt(base) || active == filepath.ToSlash(relPath) {
+			return entry.sheetName, nil
+		}
+	}
+
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.sheetName
+	}
+	return "", fmt.Errorf("-active %q matches no sheet created; the sheets are: %s", active, strings.Join(names, ", "))
+}
+
+// Read a -names file of sourcefile,sheetname rows, where sourcefile is the
+// base name of a CSV or its path relative to the scanned directory
+func loadSheetNameMap(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	sheetMap := make(map[string]string)
+	for _, record := range records {
+		source, sheetName := filepath.ToSlash(strings.TrimSpace(record[0])), strings.TrimSpace(record[1])
+		if source == "" || sheetName == "" {
+			return nil, fmt.Errorf("%s: empty source file or sheet name in %q", path, strings.Join(record, ","))
+		}
+		sheetMap[source] = sheetName
+	}
+	return sheetMap, nil
+}
+
+// Look up the sheet name mapped to a CSV by its path relative to the scanned
+// directory, then by its base name, recording the entry used
+func lookupSheetName(rootDir, csvFilePath string, sheetMap map[string]string, used map[string]bool) (string, bool) {
+	var keys []string
+	if relPath, err := filepath.Rel(rootDir, csvFilePath); err == nil {
+		keys = append(keys, filepath.ToSlash(relPath))
+	}
+	keys = append(keys, filepath.Base(csvFilePath))
+
+	for _, key := range keys {
+		if sheetName, ok := sheetMap[key]; ok {
+			used[key] = true
+			return sheetName, true
+		}
+	}
+	return "", false
+}
+
+// Derive a sheet name from the path of a CSV relative to the scanned
+// directory, joining its parts with underscores (sales/jan.csv becomes
+// sales_jan) and dropping leading directories while the name is too long
+func qualifiedSheetName(rootDir, csvFilePath string) string {
+	relPath, err := filepath.Rel(rootDir, csvFilePath)
+	if err != nil {
+		return csvxls.SheetNameFromFile(csvFilePath)
+	}
+
+	parts := strings.Split(filepath.ToSlash(csvxls.TrimCSVExt(relPath)), "/")
+	for len(parts) > 1 && len(strings.Join(parts, "_")) > 31 {
+		parts = parts[1:]
+	}
+	return csvxls.ValidSheetName(strings.Join(parts, "_"))
+}
+
+// Tab colors of -color-by-folder, the Office theme accents and their darker
+// shades
+var folderTabColors = []string{
+	"4472C4", "ED7D31", "A5A5A5", "FFC000", "5B9BD5", "70AD47",
+	"264478", "9E480E", "636363", "997300", "255E91", "43682B",
+}
+
+// Pick the tab color of a CSV from a hash of the path of its folder relative
+// to the scanned directory, so the same folder gets the same color on every run
+func folderTabColor(rootDir, csvFilePath string) string {
+	folder := filepath.Dir(csvFilePath)
+	if relPath, err := filepath.Rel(rootDir, folder); err == nil {
+		folder = relPath
+	}
+
+	hash := fnv.New32a()
+	hash.Write([]byte(filepath.ToSlash(folder)))
+	return folderTabColors[hash.Sum32()%uint32(len(folderTabColors))]
+}
+
+// Process all CSV files in a directory (single sheet stacking the rows of
+// every file, with the header of the first one only)
+func processDirectoryAppend(ctx context.Context, dirPath string, opts options) error {
+	// Verify that the directory exists
+	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+		return fmt.Errorf("directory %s does not exist", dirPath)
+	}
+
+	// Name of the output Excel file
+	dirName := combinedName(dirPath, opts)
+	xlsxFilePath := filepath.Join(dirPath, dirName+opts.ext)
+	if opts.outputDir != "" {
+		var err error
+		if xlsxFilePath, err = prepareOutputPath(filepath.Join(opts.outputDir, dirName+opts.ext), opts.ext); err != nil {
+			return err
+		}
+	}
+
+	// Keep the workbook if it already exists with -no-clobber
+	if err := opts.checkClobber(xlsxFilePath); err != nil && opts.workbook == "" {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %s: %v\n", dirPath, err)
+		return nil
+	}
+
+	// Collect all CSV files
+	csvFiles, excludedCount, err := collectCSVFiles(dirPath, opts)
+	if err != nil {
+		return err
+	}
+	if len(csvFiles) == 0 {
+		opts.summaryf("No CSV files found in the directory")
+		return nil
+	}
+
+	// Keep the workbook when -incremental finds no CSV file changed
+	if opts.incremental {
+		opts.cache = loadConversionCache(dirPath)
+		if skipUnchangedAll(csvFiles, xlsxFilePath, opts) {
+			return nil
+		}
+	}
+
+	// Create a new Excel file
+	f := excelize.NewFile()
+	defer f.Close()
+	defaultSheet := f.GetSheetName(0) // Usually "Sheet1"
+	sheetName := csvxls.ValidSheetName(dirName)
+
+	// Write the rows of each file below those of the previous one; a
+	// failed file would leave a gap or partial rows, so it stops the run
+	var header []string
+	nextRow := 1
+	skippedCount := 0
+	widths := map[int]float64{} // Widest width of each column over all files
+	for i, csvFilePath := range csvFiles {
+		// Stop before the next file once canceled
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// Time the conversion of the file for -log
+		metrics.begin()
+
+		fileHeader, err := readCSVHeader(csvFilePath, opts)
+		if err != nil {
+			metrics.record(csvFilePath, xlsxFilePath, sheetName, csvxls.Stats{}, err)
+			return fmt.Errorf("conversion failed for %s, %s not written: %v", csvFilePath, xlsxFilePath, err)
+		}
+
+		// Only the first file with rows writes its header
+		var stats csvxls.Stats
+		fileOpts := opts
+		fileOpts.Stats = &stats
+		if nextRow == 1 {
+			header = fileHeader
+		} else if opts.AddHeader != nil {
+			// The titles are written once, above the rows of the first file
+			fileOpts.AddHeader = nil
+		} else {
+			fileOpts.SkipHeader = true
+			if !slices.EqualFunc(fileHeader, header, func(a, b string) bool { return strings.TrimSpace(a) == strings.TrimSpace(b) }) {
+				fmt.Fprintf(os.Stderr, "Warning: the header of %s differs from the first file's, its rows are appended anyway\n", csvFilePath)
+			}
+		}
+
+		err = convertCSVtoSheet(ctx, csvFilePath, f, sheetName, nextRow, fileOpts)
+		metrics.record(csvFilePath, xlsxFilePath, sheetName, stats, err)
+		if errors.Is(err, csvxls.ErrNoData) {
+			// A header written by the first file is overwritten by the next one
+			opts.infof("Skipped %s: it has no data rows", csvFilePath)
+			skippedCount++
+			opts.progressf(i+1, len(csvFiles), csvFilePath)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("conversion failed for %s, %s not written: %v", csvFilePath, xlsxFilePath, err)
+		}
+		if stats.Rows > 0 {
+			opts.infof("Rows %d to %d of sheet '%s' appended from %s", nextRow, nextRow+stats.Rows-1, sheetName, csvFilePath)
+		}
+		nextRow += stats.Rows
+		opts.progressf(i+1, len(csvFiles), csvFilePath)
+
+		// Each conversion sizes the columns for its own rows only
+		for col := 1; col <= stats.Columns; col++ {
+			colName, _ := excelize.ColumnNumberToName(col)
+			if width, err := f.GetColWidth(sheetName, colName); err == nil {
+				widths[col] = max(widths[col], width)
+			}
+		}
+	}
+	for col, width := range widths {
+		colName, _ := excelize.ColumnNumberToName(col)
+		f.SetColWidth(sheetName, colName, colName, width)
+	}
+
+	// Show the data sheet on opening
+	index, _ := f.GetSheetIndex(sheetName)
+	f.SetActiveSheet(index)
+	if sheetName != defaultSheet && !opts.KeepDefaultSheet {
+		f.DeleteSheet(defaultSheet)
+	}
+
+	// Describe the workbook, titled after the directory
+	if err := csvxls.SetProperties(f, dirName, opts.Options); err != nil {
+		return err
+	}
+
+	// Save the Excel file atomically
+	if err := csvxls.SaveAtomically(f, xlsxFilePath, excelize.Options{Password: opts.Password}); err != nil {
+		return err
+	}
+
+	if opts.cache != nil {
+		opts.cache.updateAll(csvFiles, xlsxFilePath)
+		opts.cache.save()
+	}
+
+	// Print statistics
+	opts.summaryf("\nExcel file created: %s", xlsxFilePath)
+	opts.summaryf("Summary: %d files appended to sheet '%s' (%d rows), %d excluded%s", len(csvFiles)-skippedCount, sheetName, nextRow-1, excludedCount, skippedSummary(skippedCount))
+	return nil
+}
+
+// Read the header of a CSV file as the conversion would write it
+func readCSVHeader(csvFilePath string, opts options) ([]string, error) {
+	csvFile, err := csvxls.OpenCSV(csvFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open CSV file: %v", err)
+	}
+	defer csvFile.Close()
+
+	if opts.Separator == 0 {
+		opts.Separator = csvxls.DefaultSeparator(csvFilePath)
 	}
+	return csvxls.ReadHeader(csvFile, opts.Options)
 }
 
-// Custom function for help
-func customHelp() {
-	fmt.Println("Usage: csvtoxls [options]")
-	fmt.Println("\nOptions:")
-	fmt.Println("  -f file.csv     Converts a single CSV file to XLSX")
-	fmt.Println("  -d directory    Converts all CSV files in the specified directory")
-	fmt.Println("  -s              In directory mode, creates a single Excel file with multiple sheets")
-	fmt.Println("                  instead of creating one XLSX file per CSV")
-	fmt.Println("  -h, --help      Shows this help message")
-	fmt.Println("\nExamples:")
-	fmt.Println("  csvtoxls -f data.csv                   # Converts a single file")
-	fmt.Println("  csvtoxls -d ./data                     # Converts all CSVs to separate files")
-	fmt.Println("  csvtoxls -d ./data -s                  # Converts all CSVs to a single Excel file")
-	fmt.Println("\nNotes:")
-	fmt.Println("  - The default separator is semicolon (;)")
-	fmt.Println("  - Quotes are removed from values")
-	fmt.Println("  - Column widths are automatically adjusted to fit content")
-	fmt.Println("  - Existing files will be overwritten without warning")
+// Maximum decimals of -float-decimals, the most an Excel number format shows
+const maxFloatDecimals = 30
+
+// Name of the sheet written with -index
+const indexSheetName = "Index"
+
+// Data sheet listed in the index sheet
+type indexEntry struct {
+	sheetName string
+	source    string
+	rows      int
 }
 
-// Process a single CSV file
-func processFile(csvFilePath, sheetName string) error {
-	// Verify that the file exists
-	if _, err := os.Stat(csvFilePath); os.IsNotExist(err) {
-		return fmt.Errorf("file %s does not exist", csvFilePath)
+// Write the index sheet: a bold header, then the name of each data sheet
+// linking to it, its source file and its number of rows
+func writeIndexSheet(f *excelize.File, entries []indexEntry) error {
+	if _, err := f.NewSheet(indexSheetName); err != nil {
+		return fmt.Errorf("unable to create sheet %s: %v", indexSheetName, err)
 	}
 
-	// Verify that the file has a .csv extension
-	if !strings.HasSuffix(strings.ToLower(csvFilePath), ".csv") {
-		return fmt.Errorf("file %s is not a CSV file", csvFilePath)
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return fmt.Errorf("error creating header style: %v", err)
+	}
+	linkStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Color: "#0563C1", Underline: "single"}})
+	if err != nil {
+		return fmt.Errorf("error creating link style: %v", err)
 	}
 
-	// If no sheet name is specified, use the file name
-	if sheetName == "" {
-		// Extract the file name without extension
-		baseName := filepath.Base(csvFilePath)
-		sheetName = strings.TrimSuffix(baseName, filepath.Ext(baseName))
+	if err := f.SetSheetRow(indexSheetName, "A1", &[]interface{}{"Sheet", "Source", "Rows"}); err != nil {
+		return fmt.Errorf("error writing index header: %v", err)
+	}
+	if err := f.SetCellStyle(indexSheetName, "A1", "C1", headerStyle); err != nil {
+		return fmt.Errorf("error setting header style: %v", err)
+	}
 
-		// Make sure the sheet name is valid for Excel (max 31 characters, no special characters)
-		if len(sheetName) > 31 {
-			sheetName = sheetName[:31]
+	nameWidth, sourceWidth := len("Sheet"), len("Source")
+	for i, entry := range entries {
+		row := i + 2
+		nameCell, _ := excelize.CoordinatesToCellName(1, row)
+		if err := f.SetSheetRow(indexSheetName, nameCell, &[]interface{}{entry.sheetName, entry.source, entry.rows}); err != nil {
+			return fmt.Errorf("error writing index row: %v", err)
 		}
-		// Replace invalid characters with underscores
-		sheetName = sanitizeSheetName(sheetName)
-	}
 
-	// Create name for the Excel file
-	xlsxFilePath := strings.TrimSuffix(csvFilePath, filepath.Ext(csvFilePath)) + ".xlsx"
+		// Jump to the first cell of the data sheet
+		location := "'" + strings.ReplaceAll(entry.sheetName, "'", "''") + "'!A1"
+		if err := f.SetCellHyperLink(indexSheetName, nameCell, location, "Location"); err != nil {
+			return fmt.Errorf("error setting index link: %v", err)
+		}
+		if err := f.SetCellStyle(indexSheetName, nameCell, nameCell, linkStyle); err != nil {
+			return fmt.Errorf("error setting link style: %v", err)
+		}
 
-	// Create a new Excel file
-	f := excelize.NewFile()
+		nameWidth = max(nameWidth, utf8.RuneCountInString(entry.sheetName))
+		sourceWidth = max(sourceWidth, utf8.RuneCountInString(entry.source))
+	}
 
-	// Get the default sheet name
-	defaultSheet := f.GetSheetName(0) // Usually "Sheet1"
+	// Fit the name and source columns, keeping the usual limits
+	f.SetColWidth(indexSheetName, "A", "A", float64(min(max(nameWidth+2, 8), 100)))
+	f.SetColWidth(indexSheetName, "B", "B", float64(min(max(sourceWidth+2, 8), 100)))
 
-	// Create a new sheet with the appropriate name
-	f.NewSheet(sheetName)
+	return nil
+}
 
-	// Convert the CSV content
-	columnWidths, err := convertCSVtoSheet(csvFilePath, f, sheetName)
+// Open an existing workbook, which is saved again with the same password
+func openWorkbook(xlsxFilePath string, opts options) (*excelize.File, error) {
+	f, err := excelize.OpenFile(xlsxFilePath, excelize.Options{Password: opts.Password})
 	if err != nil {
-		return fmt.Errorf("conversion failed for %s: %v", csvFilePath, err)
+		return nil, fmt.Errorf("unable to open workbook %s: %v", xlsxFilePath, err)
+	}
+	return f, nil
+}
+
+// Convert a CSV file to a new sheet of an existing workbook, named after
+// the file (or -sheet) with a numeric suffix if the workbook already has it
+func addFileToWorkbook(ctx context.Context, csvFilePath, xlsxFilePath string, opts options) (err error) {
+	// Count the file in the run metrics
+	metrics.begin()
+	var sheetName string
+	var stats csvxls.Stats
+	opts.Stats = &stats
+	defer func() {
+		metrics.record(csvFilePath, xlsxFilePath, sheetName, stats, err)
+	}()
+
+	// Verify that the file exists
+	if csvFilePath != csvxls.StdinPath {
+		if _, err := os.Stat(csvFilePath); os.IsNotExist(err) {
+			return fmt.Errorf("file %s does not exist", csvFilePath)
+		}
 	}
 
-	// Adjust column widths to fit content
-	adjustColumnWidths(f, sheetName, columnWidths)
+	f, err := openWorkbook(xlsxFilePath, opts)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Name the sheet, avoiding the sheets already in the workbook
+	sheetName = "Sheet1"
+	if opts.SheetName != "" {
+		sheetName = csvxls.ValidSheetName(opts.SheetName)
+	} else if csvFilePath != csvxls.StdinPath {
+		sheetName = csvxls.SheetNameFromFile(csvFilePath)
+	}
+	sheetNames := make(map[string]bool)
+	for _, name := range f.GetSheetList() {
+		sheetNames[strings.ToLower(name)] = true
+	}
+	sheetName = csvxls.UniqueSheetName(sheetName, sheetNames)
 
-	// Set the active sheet
+	// Convert the CSV content to the new sheet and show it on opening
+	if err := convertCSVtoSheet(ctx, csvFilePath, f, sheetName, 1, opts); err != nil {
+		return fmt.Errorf("conversion failed for %s: %w", csvFilePath, err)
+	}
 	index, _ := f.GetSheetIndex(sheetName)
 	f.SetActiveSheet(index)
 
-	// Delete the default sheet after setting the active sheet
-	f.DeleteSheet(defaultSheet)
+	// Only the given properties change in an existing workbook
+	if err := csvxls.SetProperties(f, "", opts.Options); err != nil {
+		return err
+	}
 
-	// Save the Excel file
-	err = f.SaveAs(xlsxFilePath)
-	if err != nil {
-		return fmt.Errorf("error saving Excel file %s: %v", xlsxFilePath, err)
+	// Save atomically so an interrupted run never corrupts the workbook
+	if err := csvxls.SaveAtomically(f, xlsxFilePath, excelize.Options{Password: opts.Password}); err != nil {
+		return err
 	}
 
-	fmt.Printf("Conversion completed: %s -> %s\n", csvFilePath, xlsxFilePath)
+	opts.infof("Sheet '%s' added to %s from %s (%d rows, %d columns%s)", sheetName, xlsxFilePath, csvFilePath, stats.Rows, stats.Columns, longSummary(stats, opts))
 	return nil
 }
 
-// Process all CSV files in a directory (separate files)
-func processDirectory(dirPath string) error {
-	// Verify that the directory exists
-	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-		return fmt.Errorf("directory %s does not exist", dirPath)
+// Append a CSV file to a sheet of an existing workbook
+func appendFileToWorkbook(ctx context.Context, csvFilePath, target string, opts options) (err error) {
+	// Count the file in the run metrics
+	metrics.begin()
+	var xlsxFilePath, sheetName string
+	var stats csvxls.Stats
+	opts.Stats = &stats
+	defer func() {
+		metrics.record(csvFilePath, xlsxFilePath, sheetName, stats, err)
+	}()
+
+	// Verify that the file exists
+	if csvFilePath != csvxls.StdinPath {
+		if _, err := os.Stat(csvFilePath); os.IsNotExist(err) {
+			return fmt.Errorf("file %s does not exist", csvFilePath)
+		}
 	}
 
-	// Counters for statistics
-	var successCount, failCount int
+	// Split the target into workbook path and sheet name; the sheet
+	// defaults to the CSV file name
+	xlsxFilePath = target
+	if i := strings.LastIndex(target, ":"); i >= 0 && !strings.ContainsAny(target[i+1:], "/\\") {
+		xlsxFilePath, sheetName = target[:i], target[i+1:]
+	}
+	if sheetName == "" && csvFilePath == csvxls.StdinPath {
+		sheetName = "Sheet1"
+	} else if sheetName == "" {
+		sheetName = csvxls.SheetNameFromFile(csvFilePath)
+	}
 
-	// Visit all files in the directory
-	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+	// Open the existing workbook
+	f, err := openWorkbook(xlsxFilePath, opts)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Find the last used row, creating the sheet if it is missing
+	startRow := 1
+	index, err := f.GetSheetIndex(sheetName)
+	if err != nil {
+		return fmt.Errorf("invalid sheet name %s: %v", sheetName, err)
+	}
+	if index == -1 {
+		if _, err := f.NewSheet(sheetName); err != nil {
+			return fmt.Errorf("unable to create sheet %s: %v", sheetName, err)
+		}
+	} else {
+		rows, err := f.GetRows(sheetName)
 		if err != nil {
-			return err
+			return fmt.Errorf("unable to read sheet %s: %v", sheetName, err)
 		}
+		startRow = len(rows) + 1
+	}
 
-		// Skip directories
-		if d.IsDir() {
-			return nil
-		}
+	// Convert the CSV content below the existing rows, only widening columns
+	if err := convertCSVtoSheet(ctx, csvFilePath, f, sheetName, startRow, opts); err != nil {
+		return fmt.Errorf("conversion failed for %s: %w", csvFilePath, err)
+	}
 
-		// Process only CSV files
-		if strings.HasSuffix(strings.ToLower(path), ".csv") {
-			err := processFile(path, "")
-			if err != nil {
-				fmt.Printf("ERROR: %v\n", err)
-				failCount++
-			} else {
-				successCount++
-			}
-		}
+	// Only the given properties change in an existing workbook
+	if err := csvxls.SetProperties(f, "", opts.Options); err != nil {
+		return err
+	}
 
-		return nil
+	// Save atomically so an interrupted run never corrupts the workbook
+	if err := csvxls.SaveAtomically(f, xlsxFilePath, excelize.Options{Password: opts.Password}); err != nil {
+		return err
+	}
+
+	opts.infof("Append completed: %s -> %s (sheet '%s', %d rows from row %d, %d columns)", csvFilePath, xlsxFilePath, sheetName, stats.Rows, startRow, stats.Columns)
+	return nil
+}
+
+// Write the run metrics in Prometheus textfile format, atomically
+func writeMetricsFile(path string) error {
+	var b strings.Builder
+	writeMetric := func(name, help string, value interface{}) {
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", name)
+		fmt.Fprintf(&b, "%s %v\n", name, value)
+	}
+
+	writeMetric("csvtoxls_files_converted", "Number of CSV files converted in the last run.", metrics.filesConverted)
+	writeMetric("csvtoxls_files_failed", "Number of CSV files that failed to convert in the last run.", metrics.filesFailed)
+	writeMetric("csvtoxls_files_skipped", "Number of CSV files without data rows, or with an existing output under -no-clobber, skipped in the last run.", metrics.filesSkipped)
+	writeMetric("csvtoxls_files_unchanged", "Number of unchanged CSV files skipped by -incremental in the last run.", metrics.filesUnchanged)
+	writeMetric("csvtoxls_rows_written", "Number of rows written in the last run.", metrics.Rows)
+	writeMetric("csvtoxls_bytes_read", "Number of CSV bytes read in the last run.", metrics.Bytes)
+	writeMetric("csvtoxls_duration_seconds", "Duration of the last run in seconds.", time.Since(metrics.start).Seconds())
+	writeMetric("csvtoxls_last_run_timestamp_seconds", "Unix time at which the last run finished.", time.Now().Unix())
+
+	// Written atomically so the node exporter never reads a partial file
+	return csvxls.WriteFileAtomically(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, b.String())
+		return err
 	})
+}
 
-	if err != nil {
-		return fmt.Errorf("error scanning directory: %v", err)
+// Write the per-file results and the totals of the run as JSON, with the
+// error that ended the run, if any
+func writeJSONReport(w io.Writer, runErr error) error {
+	report := struct {
+		Files           []fileResult `json:"files"`
+		Converted       int          `json:"converted"`
+		Failed          int          `json:"failed"`
+		Excluded        int          `json:"excluded"`
+		Skipped         int          `json:"skipped"`
+		Unchanged       int          `json:"unchanged"`
+		Rows            int          `json:"rows"`
+		Bytes           int64        `json:"bytes"`
+		DurationSeconds float64      `json:"duration_seconds"`
+		Error           string       `json:"error,omitempty"`
+	}{
+		Files:           metrics.results,
+		Converted:       metrics.filesConverted,
+		Failed:          metrics.filesFailed,
+		Excluded:        metrics.filesExcluded,
+		Skipped:         metrics.filesSkipped,
+		Unchanged:       metrics.filesUnchanged,
+		Rows:            metrics.Rows,
+		Bytes:           metrics.Bytes,
+		DurationSeconds: time.Since(metrics.start).Seconds(),
+	}
+	if report.Files == nil {
+		report.Files = []fileResult{}
+	}
+	if runErr != nil {
+		report.Error = runErr.Error()
 	}
 
-	// Print statistics
-	fmt.Printf("\nSummary: %d files successfully converted, %d failed\n", successCount, failCount)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
 
-	if successCount == 0 && failCount == 0 {
-		fmt.Println("No CSV files found in the directory")
+// Report whether the base name of a file matches one of the exclude patterns
+func isExcluded(path string, patterns []string) bool {
+	baseName := filepath.Base(path)
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, baseName); matched {
+			return true
+		}
 	}
+	return false
+}
 
-	return nil
+// Compute the output path of a CSV found while scanning rootDir in -outdir mode
+func outputPathInDir(rootDir, csvFilePath string, opts options, usedOutputs map[string]bool) string {
+	baseName := csvxls.TrimCSVExt(filepath.Base(csvFilePath))
+
+	// Keep the relative path, which cannot collide
+	if opts.keepTree {
+		if relPath, err := filepath.Rel(rootDir, filepath.Dir(csvFilePath)); err == nil {
+			return filepath.Join(opts.outputDir, relPath, baseName+opts.ext)
+		}
+	}
+
+	// Flat layout: add a numeric suffix to duplicate names
+	outputPath := filepath.Join(opts.outputDir, baseName+opts.ext)
+	for counter := 1; usedOutputs[outputPath]; counter++ {
+		outputPath = filepath.Join(opts.outputDir, fmt.Sprintf("%s_%d%s", baseName, counter, opts.ext))
+	}
+	if outputPath != filepath.Join(opts.outputDir, baseName+opts.ext) {
+		fmt.Fprintf(os.Stderr, "Warning: %s has the same name as a previous file, writing %s\n", csvFilePath, outputPath)
+	}
+	usedOutputs[outputPath] = true
+
+	return outputPath
 }
 
-// Process all CSV files in a directory (single file with multiple sheets)
-func processDirectoryToSingleFile(dirPath string) error {
-	// Verify that the directory exists
-	if _, err := os.Stat(dirPath); os.IsNotExist(err) {
-		return fmt.Errorf("directory %s does not exist", dirPath)
+// Extensions of the workbook formats that can be written, selected by -ext
+var outputExts = []string{"xlsx", "xlsm", "xltx", "xltm", "xlam"}
+
+// Add the output extension (e.g. .xlsx) to an explicit output path if
+// missing and create its parent directory
+func prepareOutputPath(outputPath, ext string) (string, error) {
+	if !strings.HasSuffix(strings.ToLower(outputPath), ext) {
+		outputPath += ext
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return "", fmt.Errorf("unable to create output directory for %s: %v", outputPath, err)
+	}
+
+	return outputPath, nil
+}
+
+// Process a tar.gz archive of CSV files (single file with one sheet per CSV member)
+func processArchive(ctx context.Context, archivePath string, opts options) error {
+	// Open the archive
+	archiveFile, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("unable to open archive %s: %v", archivePath, err)
+	}
+	defer archiveFile.Close()
+
+	gzipReader, err := gzip.NewReader(archiveFile)
+	if err != nil {
+		return fmt.Errorf("unable to decompress archive %s: %v", archivePath, err)
 	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
 
 	// Name of the output Excel file
-	dirName := filepath.Base(dirPath)
-	xlsxFilePath := filepath.Join(dirPath, dirName+".xlsx")
+	xlsxFilePath := trimTarGzExt(archivePath) + opts.ext
+	if opts.outputPath != "" {
+		if xlsxFilePath, err = prepareOutputPath(opts.outputPath, opts.ext); err != nil {
+			return err
+		}
+	}
 
-	// Create a new Excel file
+	// Keep the workbook if it already exists with -no-clobber
+	if err := opts.checkClobber(xlsxFilePath); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %s: %v\n", archivePath, err)
+		return nil
+	}
+
+	// Create a new Excel file, closing it to remove the stream writer's temporary files
 	f := excelize.NewFile()
+	defer f.Close()
 
 	// Get the default sheet name
 	defaultSheet := f.GetSheetName(0) // Usually "Sheet1"
 
 	// Counters for statistics
-	var successCount, failCount int
+	var successCount, failCount, skippedCount int
 	var firstSheet string
 
-	// Collect all CSV files
-	var csvFiles []string
-	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
+	// Map to keep track of sheet names (to avoid duplicates), reserving
+	// the default sheet deleted at the end
+	sheetNames := map[string]bool{strings.ToLower(defaultSheet): true}
+
+	// Stream the archive members
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return err
+			return fmt.Errorf("error reading archive %s: %v", archivePath, err)
 		}
 
-		// Skip directories
-		if d.IsDir() {
-			return nil
+		// Convert only regular CSV files
+		if header.Typeflag != tar.TypeReg || !strings.HasSuffix(strings.ToLower(header.Name), ".csv") {
+			continue
 		}
 
-		// Collect only CSV files
-		if strings.HasSuffix(strings.ToLower(path), ".csv") {
-			csvFiles = append(csvFiles, path)
+		// Stop before the next member once canceled
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		return nil
-	})
-
-	if err != nil {
-		return fmt.Errorf("error scanning directory: %v", err)
-	}
-
-	// Check if there are CSV files
-	if len(csvFiles) == 0 {
-		fmt.Println("No CSV files found in the directory")
-		return nil
-	}
-
-	// Map to keep track of sheet names (to avoid duplicates)
-	sheetNames := make(map[string]bool)
-
-	// Process all CSV files
-	for _, csvFilePath := range csvFiles {
-		// Extract the file name without extension to use as sheet name
-		baseName := filepath.Base(csvFilePath)
-		sheetName := strings.TrimSuffix(baseName, filepath.Ext(baseName))
-
-		// Make sure the sheet name is valid for Excel (max 31 characters)
-		if len(sheetName) > 31 {
-			sheetName = sheetName[:31]
-		}
-
-		// Sanitize the sheet name
-		sheetName = sanitizeSheetName(sheetName)
-
-		// Handle duplicate names
-		originalName := sheetName
-		counter := 1
-		for sheetNames[sheetName] {
-			// If the name already exists, add a number
-			suffix := fmt.Sprintf("_%d", counter)
-
-			// Make sure the name with the suffix doesn't exceed 31 characters
-			if len(originalName)+len(suffix) > 31 {
-				sheetName = originalName[:31-len(suffix)] + suffix
-			} else {
-				sheetName = originalName + suffix
-			}
-
-			counter++
-		}
+		// Time the conversion of the file for -log
+		metrics.begin()
 
-		// Register the sheet name
-		sheetNames[sheetName] = true
+		// Use the member name as sheet name, avoiding duplicates
+		sheetName := csvxls.UniqueSheetName(csvxls.SheetNameFromFile(header.Name), sheetNames)
 
 		// Create a new sheet
-		_, err := f.NewSheet(sheetName)
+		_, err = f.NewSheet(sheetName)
 		if err != nil {
-			fmt.Printf("ERROR: Unable to create sheet %s: %v\n", sheetName, err)
+			fmt.Fprintf(os.Stderr, "ERROR: Unable to create sheet %s: %v\n", sheetName, err)
 			failCount++
+			metrics.record(header.Name, xlsxFilePath, sheetName, csvxls.Stats{}, err)
+			if opts.failFast {
+				break
+			}
 			continue
 		}
 
@@ -310,139 +3287,379 @@ func processDirectoryToSingleFile(dirPath string) error {
 		}
 
 		// Convert the CSV content
-		columnWidths, err := convertCSVtoSheet(csvFilePath, f, sheetName)
+		var stats csvxls.Stats
+		memberOpts := opts.Options
+		memberOpts.Stats = &stats
+		err = csvxls.ConvertReader(ctx, tarReader, f, sheetName, memberOpts)
+		metrics.record(header.Name, xlsxFilePath, sheetName, stats, err)
 		if err != nil {
-			fmt.Printf("ERROR: %v\n", err)
+			// Leave the sheet of an empty or failed file out, freeing its name
+			f.DeleteSheet(sheetName)
+			delete(sheetNames, strings.ToLower(sheetName))
+			if firstSheet == sheetName {
+				firstSheet = ""
+			}
+		}
+		if errors.Is(err, csvxls.ErrNoData) {
+			opts.infof("Skipped %s: it has no data rows", header.Name)
+			skippedCount++
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: conversion failed for %s: %v\n", header.Name, err)
 			failCount++
+			if opts.failFast {
+				break
+			}
 		} else {
-			// Adjust column widths to fit content
-			adjustColumnWidths(f, sheetName, columnWidths)
-			fmt.Printf("Sheet '%s' created from %s\n", sheetName, csvFilePath)
+			opts.infof("Sheet '%s' created from %s (%d rows, %d columns%s)", sheetName, header.Name, stats.Rows, stats.Columns, longSummary(stats, opts))
 			successCount++
 		}
 	}
 
+	// Leave the workbook unwritten when -fail-fast stopped the run
+	if opts.failFast && failCount > 0 {
+		opts.summaryf("\nSummary: aborted after %d sheets successfully created and 1 failed, %s not written", successCount, xlsxFilePath)
+		return errFailFast
+	}
+
+	// Check if there were CSV members
+	if successCount == 0 && failCount == 0 && skippedCount == 0 {
+		opts.summaryf("No CSV files found in the archive")
+		return nil
+	}
+
 	// Set the first sheet as active (if it exists)
 	if firstSheet != "" {
 		index, _ := f.GetSheetIndex(firstSheet)
 		f.SetActiveSheet(index)
 
 		// Delete the default sheet after setting the active sheet
-		f.DeleteSheet(defaultSheet)
+		if !opts.KeepDefaultSheet {
+			f.DeleteSheet(defaultSheet)
+		}
 	}
 
-	// Save the Excel file
-	err = f.SaveAs(xlsxFilePath)
-	if err != nil {
-		return fmt.Errorf("error saving Excel file %s: %v", xlsxFilePath, err)
+	// Describe the workbook, titled after the archive
+	if err := csvxls.SetProperties(f, filepath.Base(archivePath), opts.Options); err != nil {
+		return err
+	}
+
+	// Save the Excel file atomically
+	if err := csvxls.SaveAtomically(f, xlsxFilePath, excelize.Options{Password: opts.Password}); err != nil {
+		return err
 	}
 
 	// Print statistics
-	fmt.Printf("\nExcel file created: %s\n", xlsxFilePath)
-	fmt.Printf("Summary: %d sheets successfully created, %d failed\n", successCount, failCount)
+	opts.summaryf("\nExcel file created: %s", xlsxFilePath)
+	opts.summaryf("Summary: %d sheets successfully created (%d rows), %d failed%s", successCount, metrics.convertedRows(), failCount, skippedSummary(skippedCount))
 
+	if failCount > 0 {
+		return &batchError{failed: failCount, total: successCount + failCount}
+	}
 	return nil
 }
 
-// Convert a CSV to an Excel sheet and return column widths
-func convertCSVtoSheet(csvFilePath string, f *excelize.File, sheetName string) (map[int]int, error) {
-	// Open the CSV file
-	csvFile, err := os.Open(csvFilePath)
+// Look up an -encoding name; UTF-8 needs no decoder and returns nil
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(strings.ReplaceAll(name, "-", "")) {
+	case "utf8":
+		return nil, nil
+	case "latin1", "iso88591":
+		return charmap.ISO8859_1, nil
+	case "windows1252", "cp1252":
+		return charmap.Windows1252, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q (use utf8, latin1 or windows1252)", name)
+}
+
+// Report whether the path names a delimited file to convert, plain or
+// gzip-compressed: .csv and .tsv files, and .txt files when a separator is given
+func isCSVFile(path string, opts options) bool {
+	switch filepath.Ext(strings.TrimSuffix(strings.ToLower(path), ".gz")) {
+	case ".csv", ".tsv":
+		return true
+	case ".txt":
+		return opts.Separator != 0 || opts.SeparatorLiteral != "" || opts.SeparatorRegexp != nil
+	}
+	return false
+}
+
+// Report whether the path names a gzip-compressed tar archive
+func isTarGz(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// Strip the .tar.gz or .tgz extension from an archive path
+func trimTarGzExt(path string) string {
+	if strings.HasSuffix(strings.ToLower(path), ".tar.gz") {
+		return path[:len(path)-len(".tar.gz")]
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// Process an Excel file in reverse: write each sheet to a CSV file named
+// after the sheet, in the directory of the workbook, overwriting existing
+// CSV files only with -force
+func processReverse(xlsxFilePath string, opts options) (err error) {
+	// Count the workbook in the run metrics
+	metrics.begin()
+	var stats csvxls.Stats
+	defer func() {
+		metrics.record(xlsxFilePath, filepath.Dir(xlsxFilePath), "", stats, err)
+	}()
+
+	f, err := excelize.OpenFile(xlsxFilePath, excelize.Options{Password: opts.Password})
 	if err != nil {
-		return nil, fmt.Errorf("unable to open CSV file: %v", err)
+		return fmt.Errorf("unable to open workbook %s: %v", xlsxFilePath, err)
 	}
-	defer csvFile.Close()
+	defer f.Close()
 
-	// Create a new CSV reader with appropriate settings
-	reader := csv.NewReader(csvFile)
-	reader.Comma = ';'             // Set the separator as semicolon
-	reader.FieldsPerRecord = -1    // Allow variable number of fields per row
-	reader.LazyQuotes = true       // Handle quotes more flexibly
-	reader.TrimLeadingSpace = true // Remove leading spaces
+	// Without -sep, use the same separator the conversion falls back to
+	separator := opts.Separator
+	if separator == 0 {
+		separator = ';'
+	}
 
-	// Map to track the maximum width of each column
-	columnWidths := make(map[int]int)
+	// An existing CSV is most likely the source of the workbook: stop
+	// before writing anything unless -force or -no-clobber decides
+	dir := filepath.Dir(xlsxFilePath)
+	if !opts.force && !opts.noClobber {
+		for _, sheetName := range f.GetSheetList() {
+			csvFilePath := filepath.Join(dir, csvNameFromSheet(sheetName))
+			if _, err := os.Stat(csvFilePath); err == nil {
+				return fmt.Errorf("%s already exists; use -force to overwrite it or -no-clobber to skip its sheet", csvFilePath)
+			}
+		}
+	}
 
-	// Read and process the CSV row by row
-	rowIndex := 1
-	for {
-		record, err := reader.Read()
-		if err == io.EOF {
-			break
+	for _, sheetName := range f.GetSheetList() {
+		csvFilePath := filepath.Join(dir, csvNameFromSheet(sheetName))
+		if err := opts.checkClobber(csvFilePath); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipped sheet %s: %v\n", sheetName, err)
+			continue
 		}
+
+		rows, err := f.GetRows(sheetName)
 		if err != nil {
-			return nil, fmt.Errorf("error reading CSV at row %d: %v", rowIndex, err)
+			return fmt.Errorf("error reading sheet %s: %v", sheetName, err)
 		}
 
-		// Insert data into the Excel sheet
-		for colIndex, value := range record {
-			// Remove quotes at the beginning and end
-			value = strings.TrimPrefix(value, "\"")
-			value = strings.TrimSuffix(value, "\"")
-
-			// Convert indices to cell name (A1, B1, etc.)
-			cellName, err := excelize.CoordinatesToCellName(colIndex+1, rowIndex)
-			if err != nil {
-				return nil, fmt.Errorf("error converting coordinates: %v", err)
+		err = csvxls.WriteFileAtomically(csvFilePath, func(w io.Writer) error {
+			// The CSV writer quotes fields containing the separator,
+			// quotes or line breaks as described in RFC 4180
+			writer := csv.NewWriter(w)
+			writer.Comma = separator
+			if err := writer.WriteAll(rows); err != nil {
+				return err
 			}
+			return writer.Error()
+		})
+		if err != nil {
+			return err
+		}
 
-			// Set the value in the cell
-			if err := f.SetCellValue(sheetName, cellName, value); err != nil {
-				return nil, fmt.Errorf("error setting cell value: %v", err)
-			}
+		stats.Rows += len(rows)
+		for _, row := range rows {
+			stats.Columns = max(stats.Columns, len(row))
+		}
+		opts.infof("Conversion completed: %s [%s] -> %s", xlsxFilePath, sheetName, csvFilePath)
+	}
 
-			// Update the maximum width for this column
-			// Add a bit of padding (1.2 multiplier) for better appearance
-			valueWidth := int(float64(utf8.RuneCountInString(value)) * 1.2)
-			if valueWidth > columnWidths[colIndex] {
-				columnWidths[colIndex] = valueWidth
-			}
+	return nil
+}
+
+// Derive a CSV file name from a sheet name, replacing the characters that
+// Excel allows in sheet names but file systems do not
+func csvNameFromSheet(sheetName string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>"|`, r) || r < 0x20 {
+			return '_'
 		}
-		rowIndex++
+		return r
+	}, sheetName)
+	return name + ".csv"
+}
+
+// Convert a CSV file (or standard input for "-") to an Excel sheet
+// starting at startRow
+func convertCSVtoSheet(ctx context.Context, csvFilePath string, f *excelize.File, sheetName string, startRow int, opts options) error {
+	if csvFilePath == csvxls.StdinPath {
+		return csvxls.ConvertReaderAt(ctx, os.Stdin, f, sheetName, startRow, opts.Options)
+	}
+
+	// Open the CSV file, decompressing .csv.gz files
+	csvFile, err := csvxls.OpenCSV(csvFilePath)
+	if err != nil {
+		return fmt.Errorf("unable to open CSV file: %v", err)
+	}
+	defer csvFile.Close()
+
+	// Tab-separated files need no detection
+	if opts.Separator == 0 {
+		opts.Separator = csvxls.DefaultSeparator(csvFilePath)
+	}
+	if opts.SourceName == "" {
+		opts.SourceName = filepath.Base(csvFilePath)
+	}
+
+	return csvxls.ConvertReaderAt(ctx, csvFile, f, sheetName, startRow, opts.Options)
+}
+
+// Parse the -sep value into a single separator rune; the literal string \t means tab
+func parseSeparator(value string) (rune, error) {
+	if value == `\t` {
+		return '\t', nil
+	}
+
+	if utf8.RuneCountInString(value) != 1 {
+		return 0, fmt.Errorf("%q must be exactly one character", value)
+	}
+
+	separator, _ := utf8.DecodeRuneInString(value)
+	if separator == '"' || separator == '\r' || separator == '\n' || separator == utf8.RuneError {
+		return 0, fmt.Errorf("%q cannot be used as a separator", value)
+	}
+
+	return separator, nil
+}
+
+// Parse the -comment value, a single character that cannot start a quoted field or end a line
+func parseCommentChar(value string) (rune, error) {
+	if utf8.RuneCountInString(value) != 1 {
+		return 0, fmt.Errorf("%q must be exactly one character", value)
+	}
+
+	comment, _ := utf8.DecodeRuneInString(value)
+	if comment == '"' || comment == '\r' || comment == '\n' || comment == utf8.RuneError {
+		return 0, fmt.Errorf("%q cannot be used as a comment character", value)
 	}
 
-	return columnWidths, nil
+	return comment, nil
+}
+
+// Largest column width accepted by Excel
+const maxColumnWidth = 255
+
+// Parse a list of fixed column widths such as A=20,C=50 into widths by 1-based column
+func parseColumnWidths(list string) (map[int]float64, error) {
+	widths := make(map[int]float64)
+	for _, entry := range strings.Split(list, ",") {
+		ref, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			return nil, fmt.Errorf("%q must have the form COLUMN=WIDTH", entry)
+		}
+		col, err := parseColumnRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		width, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || width <= 0 || width > maxColumnWidth {
+			return nil, fmt.Errorf("width %q of column %s must be a number between 0 and %d", value, ref, maxColumnWidth)
+		}
+		widths[col] = width
+	}
+	return widths, nil
 }
 
-// Adjust column widths to fit content
-func adjustColumnWidths(f *excelize.File, sheetName string, columnWidths map[int]int) {
-	// Set minimum and maximum width limits
-	const (
-		minWidth = 8
-		maxWidth = 100
-	)
+// Cell types accepted by -types
+var columnTypeNames = map[string]csvxls.ColumnType{
+	"text":   csvxls.TypeText,
+	"number": csvxls.TypeNumber,
+	"date":   csvxls.TypeDate,
+}
 
-	// Adjust each column width
-	for colIndex, width := range columnWidths {
-		// Apply minimum and maximum constraints
-		if width < minWidth {
-			width = minWidth
-		} else if width > maxWidth {
-			width = maxWidth
+// Parse a list of forced column types such as A=text,B=number into types by 1-based column
+func parseColumnTypes(list string) (map[int]csvxls.ColumnType, error) {
+	types := make(map[int]csvxls.ColumnType)
+	for _, entry := range strings.Split(list, ",") {
+		ref, name, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found {
+			return nil, fmt.Errorf("%q must have the form COLUMN=TYPE", entry)
+		}
+		col, err := parseColumnRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		columnType, ok := columnTypeNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown type %q of column %s (text, number or date)", name, ref)
+		}
+		if _, listed := types[col]; listed {
+			return nil, fmt.Errorf("column %s is listed twice", ref)
 		}
+		types[col] = columnType
+	}
+	return types, nil
+}
 
-		// Convert column index to column name (A, B, C, etc.)
-		colName, _ := excelize.ColumnNumberToName(colIndex + 1)
+// Parse a comma-separated list of COLUMN or COLUMN:NAME entries selecting
+// the CSV columns to write
+func parseColumnList(list string) ([]csvxls.Column, error) {
+	var columns []csvxls.Column
+	for _, entry := range strings.Split(list, ",") {
+		ref, name, _ := strings.Cut(entry, ":")
+		col, err := parseColumnRef(ref)
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, csvxls.Column{Source: col, Name: strings.TrimSpace(name)})
+	}
+	return columns, nil
+}
 
-		// Set the column width
-		f.SetColWidth(sheetName, colName, colName, float64(width))
+// Split a comma-separated list of tokens, dropping the spaces around them
+// and the empty ones
+func splitTokenList(list string) []string {
+	var tokens []string
+	for _, token := range strings.Split(list, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
 	}
+	return tokens
 }
 
-// Sanitize the sheet name by removing invalid characters
-func sanitizeSheetName(name string) string {
-	// Characters not allowed in Excel sheet names: [ ] * ? / \ : '
-	invalidChars := []string{"[", "]", "*", "?", "/", "\\", ":", "'"}
-	result := name
+// Parse a comma-separated list of header titles; titles containing commas
+// can be quoted as in a CSV
+func parseHeaderList(list string) ([]string, error) {
+	reader := csv.NewReader(strings.NewReader(list))
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	for i, title := range header {
+		header[i] = strings.TrimSpace(title)
+	}
+	return header, nil
+}
 
-	for _, char := range invalidChars {
-		result = strings.ReplaceAll(result, char, "_")
+// Parse a column reference given as a letter (e.g. C) or a 1-based number (e.g. 3)
+func parseColumnRef(ref string) (int, error) {
+	ref = strings.TrimSpace(ref)
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > excelize.MaxColumns {
+			return 0, fmt.Errorf("column number %d out of range", n)
+		}
+		return n, nil
 	}
 
-	// Make sure the name is not empty
-	if result == "" {
-		result = "Sheet"
+	n, err := excelize.ColumnNameToNumber(ref)
+	if err != nil {
+		return 0, fmt.Errorf("invalid column %q", ref)
 	}
+	return n, nil
+}
 
-	return result
+// Parse a comma-separated list of #RRGGBB colors
+func parseColorList(list string) ([]string, error) {
+	var colors []string
+	for _, color := range strings.Split(list, ",") {
+		color = strings.ToUpper(strings.TrimSpace(color))
+		if !colorPattern.MatchString(color) {
+			return nil, fmt.Errorf("invalid color %q", color)
+		}
+		colors = append(colors, color)
+	}
+	return colors, nil
 }
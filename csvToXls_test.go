@@ -0,0 +1,387 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"csvtoxls/csvxls"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Options of a quiet run with the default conversion settings
+func testOptions() options {
+	opts := options{Options: csvxls.DefaultOptions(), ext: ".xlsx"}
+	opts.Quiet = true
+	return opts
+}
+
+// Write files with the given contents into dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAppendFileToWorkbook(t *testing.T) {
+	tests := []struct {
+		name       string
+		sheet      string
+		skipHeader bool
+		wantRows   int
+	}{
+		{"existing sheet without the CSV header", "Report", true, 5},
+		{"existing sheet with the CSV header", "Report", false, 6},
+		{"missing sheet", "New", false, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"data.csv": "id;amount\n4;40\n5;50\n"})
+
+			// Workbook with a header and two data rows in the Report sheet
+			workbook := filepath.Join(dir, "report.xlsx")
+			f := excelize.NewFile()
+			f.SetSheetName("Sheet1", "Report")
+			for i, row := range [][]interface{}{{"id", "amount"}, {1, 10}, {2, 20}} {
+				cell, _ := excelize.CoordinatesToCellName(1, i+1)
+				if err := f.SetSheetRow("Report", cell, &row); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := f.SaveAs(workbook); err != nil {
+				t.Fatal(err)
+			}
+			f.Close()
+
+			opts := testOptions()
+			opts.SkipHeader = tt.skipHeader
+			if err := appendFileToWorkbook(context.Background(), filepath.Join(dir, "data.csv"), workbook+":"+tt.sheet, opts); err != nil {
+				t.Fatalf("appendFileToWorkbook: %v", err)
+			}
+
+			f, err := excelize.OpenFile(workbook)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			rows, err := f.GetRows(tt.sheet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != tt.wantRows {
+				t.Errorf("sheet %s has %d rows, want %d: %q", tt.sheet, len(rows), tt.wantRows, rows)
+			}
+			if last := rows[len(rows)-1]; last[0] != "5" || last[1] != "50" {
+				t.Errorf("last row = %q, want the last CSV row", last)
+			}
+			if report, _ := f.GetRows("Report"); len(report) < 3 || report[1][1] != "10" {
+				t.Errorf("existing rows of Report were changed: %q", report)
+			}
+		})
+	}
+}
+
+// Write a tar.gz archive holding the given members in order
+func writeTarGz(t *testing.T, archivePath string, members [][2]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, member := range members {
+		header := &tar.Header{Name: member[0], Mode: 0644, Size: int64(len(member[1])), Typeflag: tar.TypeReg}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(member[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archivePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProcessArchive(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "export.tar.gz")
+	writeTarGz(t, archivePath, [][2]string{
+		{"sales.csv", "id;amount\n1;10\n2;20\n"},
+		{"README.txt", "not a CSV\n"},
+		{"data/stock.csv", "item;qty\nbolt;5\n"},
+	})
+
+	if err := processArchive(context.Background(), archivePath, testOptions()); err != nil {
+		t.Fatalf("processArchive: %v", err)
+	}
+
+	f, err := excelize.OpenFile(strings.TrimSuffix(archivePath, ".tar.gz") + ".xlsx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want := map[string][][]string{
+		"sales": {{"id", "amount"}, {"1", "10"}, {"2", "20"}},
+		"stock": {{"item", "qty"}, {"bolt", "5"}},
+	}
+	if got := f.GetSheetList(); !slices.Equal(got, []string{"sales", "stock"}) {
+		t.Fatalf("sheets = %q, want sales and stock", got)
+	}
+	for sheet, wantRows := range want {
+		rows, err := f.GetRows(sheet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.EqualFunc(rows, wantRows, slices.Equal) {
+			t.Errorf("sheet %s rows = %q, want %q", sheet, rows, wantRows)
+		}
+	}
+}
+
+func TestWriteMetricsFile(t *testing.T) {
+	saved := metrics
+	t.Cleanup(func() { metrics = saved })
+	metrics = runMetrics{start: time.Now().Add(-2 * time.Second)}
+
+	// Two converted files, an empty one skipped and a missing one
+	dir := t.TempDir()
+	files := map[string]string{"a.csv": "id;amount\n1;10\n2;20\n", "b.csv": "id\n3\n", "empty.csv": ""}
+	writeFiles(t, dir, files)
+	opts := testOptions()
+	opts.Stats = &metrics.Stats
+	opts.SkipEmptyFiles = true
+	for _, name := range []string{"a.csv", "b.csv", "empty.csv", "missing.csv"} {
+		processFile(context.Background(), filepath.Join(dir, name), opts)
+	}
+
+	path := filepath.Join(t.TempDir(), "csvtoxls.prom")
+	if err := writeMetricsFile(path); err != nil {
+		t.Fatalf("writeMetricsFile: %v", err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every metric is a gauge with its help line and a numeric value
+	values := make(map[string]float64)
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, " ")
+		number, err := strconv.ParseFloat(value, 64)
+		if !ok || err != nil {
+			t.Fatalf("malformed metric line %q", line)
+		}
+		if !strings.Contains(string(content), "# TYPE "+name+" gauge\n") {
+			t.Errorf("metric %s has no gauge TYPE line", name)
+		}
+		values[name] = number
+	}
+
+	want := map[string]float64{
+		"csvtoxls_files_converted": 2,
+		"csvtoxls_files_failed":    1,
+		"csvtoxls_files_skipped":   1,
+		"csvtoxls_rows_written":    5, // Header rows included
+		"csvtoxls_bytes_read":      float64(len(files["a.csv"]) + len(files["b.csv"])),
+	}
+	for name, want := range want {
+		if got, ok := values[name]; !ok || got != want {
+			t.Errorf("%s = %v (present: %v), want %v", name, got, ok, want)
+		}
+	}
+	if duration := values["csvtoxls_duration_seconds"]; duration < 2 || duration > 60 {
+		t.Errorf("csvtoxls_duration_seconds = %v, want the time since the start of the run", duration)
+	}
+	if timestamp := values["csvtoxls_last_run_timestamp_seconds"]; math.Abs(timestamp-float64(time.Now().Unix())) > 60 {
+		t.Errorf("csvtoxls_last_run_timestamp_seconds = %v, want the current time", timestamp)
+	}
+
+	// Written atomically, with no temporary file left behind
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("directory holds %d files, want only the metrics file", len(entries))
+	}
+}
+
+func TestSingleFileLeavesFailedSheetsOut(t *testing.T) {
+	const good, bad, empty = "id;amount\n1;10\n", "id;amount\n1;\xff\n", ""
+	tests := []struct {
+		name       string
+		files      map[string]string
+		skipEmpty  bool
+		wantSheets []string
+		wantActive string
+		wantFailed int
+	}{
+		{
+			"failed file between good ones",
+			map[string]string{"a.csv": good, "b.csv": bad, "c.csv": good},
+			false, []string{"a", "c"}, "a", 1,
+		},
+		{
+			"failed first file",
+			map[string]string{"a.csv": bad, "b.csv": good},
+			false, []string{"b"}, "b", 1,
+		},
+		{
+			"skipped empty file",
+			map[string]string{"a.csv": empty, "b.csv": good},
+			true, []string{"b"}, "b", 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "batch")
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeFiles(t, dir, tt.files)
+
+			opts := testOptions()
+			opts.StrictUTF8 = true
+			opts.SkipEmptyFiles = tt.skipEmpty
+			err := processDirectoryToSingleFile(context.Background(), dir, opts)
+			var batchErr *batchError
+			if tt.wantFailed == 0 {
+				if err != nil {
+					t.Fatalf("processDirectoryToSingleFile: %v", err)
+				}
+			} else if !errors.As(err, &batchErr) || batchErr.failed != tt.wantFailed {
+				t.Fatalf("processDirectoryToSingleFile error = %v, want %d failed", err, tt.wantFailed)
+			}
+
+			f, err := excelize.OpenFile(filepath.Join(dir, "batch.xlsx"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if got := f.GetSheetList(); !slices.Equal(got, tt.wantSheets) {
+				t.Errorf("sheets = %q, want %q", got, tt.wantSheets)
+			}
+			if got := f.GetSheetName(f.GetActiveSheetIndex()); got != tt.wantActive {
+				t.Errorf("active sheet = %q, want %q", got, tt.wantActive)
+			}
+		})
+	}
+}
+
+func TestProcessReverseKeepsSourceCSV(t *testing.T) {
+	const source = "id;name\n1;a,b\n"
+	tests := []struct {
+		name      string
+		force     bool
+		noClobber bool
+		wantErr   bool
+		want      string // Content of s.csv after the reverse conversion
+	}{
+		{"refused", false, false, true, source},
+		{"skipped with -no-clobber", false, true, false, source},
+		{"overwritten with -force", true, false, false, "id,name\n1,\"a,b\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"s.csv": source})
+			csvPath := filepath.Join(dir, "s.csv")
+			if err := processFile(context.Background(), csvPath, testOptions()); err != nil {
+				t.Fatalf("processFile: %v", err)
+			}
+
+			opts := testOptions()
+			opts.Separator = ','
+			opts.force = tt.force
+			opts.noClobber = tt.noClobber
+			err := processReverse(filepath.Join(dir, "s.xlsx"), opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("processReverse error = %v, want error: %v", err, tt.wantErr)
+			}
+
+			content, err := os.ReadFile(csvPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.want {
+				t.Errorf("s.csv = %q, want %q", content, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogResult(t *testing.T) {
+	savedMetrics, savedLog := metrics, runLog
+	t.Cleanup(func() { metrics, runLog = savedMetrics, savedLog })
+
+	path := filepath.Join(t.TempDir(), "run.log")
+	logFile, err := openRunLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logFile.Close()
+	runLog = log.New(logFile, "", 0)
+
+	// A run started long ago, as a -watch run idle between files
+	metrics = runMetrics{start: time.Now().Add(-time.Hour)}
+	metrics.begin()
+	metrics.record("a.csv", "a.xlsx", "", csvxls.Stats{Rows: 2, Columns: 1}, nil)
+
+	// The entry is in the file before it is closed
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const prefix = "converted a.csv -> a.xlsx: 2 rows, 1 columns in "
+	line := strings.TrimSpace(string(content))
+	if !strings.HasPrefix(line, prefix) {
+		t.Fatalf("log = %q, want a converted entry", content)
+	}
+	elapsed, err := time.ParseDuration(strings.TrimPrefix(line, prefix))
+	if err != nil || elapsed > time.Minute {
+		t.Errorf("logged time %q, want the time spent on the file only", strings.TrimPrefix(line, prefix))
+	}
+}
+
+func TestProcessWatchStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var messages bytes.Buffer
+	opts := testOptions()
+	opts.Messages = &messages
+
+	done := make(chan error, 1)
+	go func() { done <- processWatch(ctx, t.TempDir(), opts) }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("processWatch() error = %v, want nil after Ctrl-C", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("processWatch() did not stop")
+	}
+	if !strings.Contains(messages.String(), "Stopped watching") {
+		t.Errorf("messages = %q, want a Stopped watching message", messages.String())
+	}
+}
@@ -0,0 +1,3193 @@
+// Package csvxls converts CSV data to sheets of Excel (XLSX) workbooks.
+//
+// It is the conversion engine of the csvtoxls command: the value typing,
+// column detection and formatting applied to each sheet are driven by Options.
+package csvxls
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/width"
+)
+
+// Options control how CSV content is read and written to a sheet.
+// The zero value reads UTF-8 with a detected separator and stores every
+// value as text; DefaultOptions returns the settings of the command.
+type Options struct {
+	Separator  rune              // CSV field separator (0 to detect it from the content)
+	Comment    rune              // Lines starting with this character are skipped (0 to disable)
+	Encoding   encoding.Encoding // Input encoding for content without BOM (nil for UTF-8)
+	StrictUTF8 bool              // Fail on invalid UTF-8 instead of replacing it with U+FFFD
+	Raw        bool              // Split each line on the separator as is, without CSV quote processing
+
+	// A separator of several characters or a pattern splits the lines like
+	// Raw and overrides Separator; the pattern must not match empty text
+	SeparatorLiteral string
+	SeparatorRegexp  *regexp.Regexp
+	Verbose          bool      // Print additional details about each conversion
+	Quiet            bool      // Print only errors, warnings and summaries
+	Messages         io.Writer // Destination of the informational and Verbose messages (nil for standard output)
+
+	KeepQuotes  bool     // Keep the quote left at the start or end of a value after CSV unquoting (always with Raw)
+	Trim        bool     // Remove leading and trailing whitespace from every value
+	Columns     []Column // CSV columns to write, in this order (nil for all); other column options refer to the written columns
+	Transpose   bool     // Write the records as columns, reading the whole CSV in memory; the other options refer to the transposed rows
+	TypeNumbers bool     // Store numeric values as numbers instead of text
+	NumFormat   string   // Excel number format of the columns holding only numbers (empty to disable)
+	// Decimals displayed in the columns holding only numbers, some with a
+	// fractional part, unless NumFormat is set (0 to disable)
+	FloatDecimals int
+	DecimalComma  bool     // Read numbers written with a decimal comma and dot thousands separators (1.234,56)
+	Hyperlinks    bool     // Store http:// and https:// URLs as clickable links
+	DateLayouts   []string // Go layouts of date values to convert (nil to disable)
+	DateFormat    string   // Excel number format used to display converted dates
+
+	ColumnTypes       map[int]ColumnType // Cell types forced by 1-based column, overriding the detection
+	ColumnDateLayouts []string           // Go layouts of the TypeDate columns (nil for DateLayouts)
+
+	UpdatedCell   string // Cell receiving the "last updated" timestamp (empty to disable)
+	UpdatedFormat string // Excel number format used to display the timestamp
+	Durations     bool   // Convert columns of ISO-8601 durations to Excel time values
+	Currency      bool   // Convert columns of currency amounts to formatted numbers
+	Percent       bool   // Convert columns of percentages (12.5%) to fractions (0.125) in a percent format
+	PercentFormat string // Excel number format of the percentage columns (empty for 0.0%)
+
+	Bools       bool     // Convert columns of true/false tokens to Excel booleans
+	TrueValues  []string // Tokens read as TRUE by Bools, case-insensitive (nil for true and yes)
+	FalseValues []string // Tokens read as FALSE by Bools, case-insensitive (nil for false and no)
+
+	ColorScaleCol    int      // 1-based column receiving a color scale (0 to disable)
+	ColorScaleColors []string // Color scale colors from lowest to highest value (2 or 3)
+	Heatmap          bool     // Add the color scale to every column holding numbers only, below the header
+
+	SummaryCol int // 1-based column summarized by the COUNT/SUM/AVERAGE panel (0 to disable)
+
+	FreezeCols int // Number of leftmost columns kept visible while scrolling right (0 to disable)
+
+	GroupStripeCol int  // 1-based key column whose runs of equal values are shaded alternately (0 to disable)
+	Zebra          bool // Shade every other data row below the header
+
+	AlignByType bool // Right-align mostly numeric or date columns and left-align text ones, below the header
+
+	Title   string // Title document property (empty for the name of the source)
+	Author  string // Author document property (empty to leave it unchanged)
+	Company string // Company document property (empty to leave it unchanged)
+
+	SheetName        string // Sheet name used by ConvertFile (empty to derive it from the file name)
+	Password         string // Password encrypting the workbook saved by ConvertFile (empty for none)
+	KeepDefaultSheet bool   // Keep the empty default sheet ("Sheet1") in new workbooks
+
+	MinWidth    int             // Minimum automatic column width (0 for 8)
+	MaxWidth    int             // Maximum automatic column width (0 for 100)
+	WidthFactor float64         // Multiplier from characters to automatic column width (0 for 1.2)
+	SmartWidth  bool            // Count East Asian wide and fullwidth characters as two in automatic widths
+	FixedWidth  float64         // Width of every used column instead of the automatic widths (0 for automatic)
+	ColWidths   map[int]float64 // Fixed widths by 1-based column, overriding the automatic ones
+	Wrap        bool            // Wrap the text of columns whose content is wider than MaxWidth
+	WrapLines   bool            // Wrap the text of the cells holding line breaks, so that they show on several lines
+	Font        string          // Font family of the written cells (empty for the workbook default)
+	FontSize    float64         // Font size of the written cells, in points (0 for the workbook default)
+
+	Stream bool // Write rows through a StreamWriter instead of keeping the sheet in memory
+
+	HeaderOnly     bool       // Write only the styled header row (template mode)
+	Header         HeaderMode // Whether the first row is bolded and frozen as a header
+	AutoFilter     bool       // Add autofilter dropdowns to the header row
+	Table          bool       // Register the header and data as an Excel table
+	SkipHeader     bool       // Do not write the first CSV row
+	AddHeader      []string   // Header written before the first CSV row, or in its place with SkipHeader (nil to disable)
+	SkipEmpty      bool       // Do not write records whose fields are all empty
+	SkipEmptyFiles bool       // Fail with ErrNoData instead of marking the sheet "(empty)" when the CSV has no data rows
+	Pad            bool       // Pad short records with empty cells up to the widest one
+	SkipLines      int        // Number of leading records (preamble) discarded before anything is written
+	MaxRows        int        // Stop after this many data rows, not counting the header (0 for no limit)
+	MaxCellLen     int        // Longest value in characters, longer ones end with … (0 for Excel's limit of 32767)
+	SplitLongCells bool       // Spill the characters over MaxCellLen into the next cells of the row instead
+
+	// Header of a column prepended to every row, holding SourceName in the
+	// data rows (empty to disable); the other column options count it
+	SourceColumn string
+	SourceName   string // Source written by SourceColumn, set by ConvertFile from the file name
+
+	// Split the data of ConvertFile over sheets of at most this many data
+	// rows, named after the sheet with a _1, _2, ... suffix and each
+	// starting with the header (0 for a single sheet)
+	RowsPerSheet int
+
+	Stats *Stats // Receives the totals of the conversions (nil to disable)
+}
+
+// HeaderMode tells whether the first row written to a new sheet is bolded
+// and frozen as a header
+type HeaderMode int
+
+const (
+	HeaderNever  HeaderMode = iota // Leave the first row like the others
+	HeaderAlways                   // Treat the first row as a header
+	HeaderAuto                     // Treat the first row as a header when it looks like one
+)
+
+// Column selects a CSV column to write with Options.Columns
+type Column struct {
+	Source int    // 1-based column of the CSV
+	Name   string // Header written instead of the CSV one (empty to keep it)
+}
+
+// Stats accumulates the totals of one or more conversions
+type Stats struct {
+	Rows    int   // Rows written
+	Columns int   // Columns of the widest sheet written
+	Bytes   int64 // CSV bytes read
+	Long    int   // Values over MaxCellLen, truncated or split
+}
+
+// File name standing for standard input
+const StdinPath = "-"
+
+// Workbook path standing for standard output in ConvertFile, which then
+// writes an xlsx workbook
+const StdoutPath = "-"
+
+// ColumnType is the cell type forced on a column by Options.ColumnTypes
+type ColumnType int
+
+const (
+	TypeAuto   ColumnType = iota // Detected from each value, as unlisted columns
+	TypeText                     // Always text, displayed with the text format
+	TypeNumber                   // A number whenever the value parses as one, leading zeros included
+	TypeDate                     // A date whenever the value matches a date layout
+)
+
+// ErrNoData is returned with Options.SkipEmptyFiles when the CSV content
+// has no data rows, being empty or holding only its header
+var ErrNoData = errors.New("the CSV has no data rows")
+
+// Marker written below the header of a new sheet whose CSV has no data rows
+const emptyMarker = "(empty)"
+
+// DefaultOptions returns the options used by the command without flags
+func DefaultOptions() Options {
+	return Options{
+		TypeNumbers:      true,
+		DateFormat:       "yyyy-mm-dd",
+		UpdatedFormat:    "yyyy-mm-dd hh:mm:ss",
+		ColorScaleColors: []string{"#F8696B", "#FFEB84", "#63BE7B"},
+	}
+}
+
+// ConvertFile converts a CSV file (or standard input for "-") to a new
+// workbook saved at xlsxPath (or written to standard output for "-"), with
+// a single sheet named after the file unless Options.SheetName is set.
+// Nothing is saved when ctx is canceled during the conversion.
+func ConvertFile(ctx context.Context, csvPath, xlsxPath string, opts Options) error {
+	// Standard input has no name to derive the sheet name from
+	sheetName := "Sheet1"
+	var r io.Reader = os.Stdin
+	if csvPath != StdinPath {
+		sheetName = SheetNameFromFile(csvPath)
+		if opts.SourceName == "" {
+			opts.SourceName = filepath.Base(csvPath)
+		}
+
+		csvFile, err := OpenCSV(csvPath)
+		if err != nil {
+			return fmt.Errorf("conversion failed for %s: unable to open CSV file: %v", csvPath, err)
+		}
+		defer csvFile.Close()
+		r = csvFile
+	}
+
+	// Tab-separated files need no detection
+	if opts.Separator == 0 {
+		opts.Separator = DefaultSeparator(csvPath)
+	}
+
+	// An explicit sheet name overrides the derived one
+	if opts.SheetName != "" {
+		sheetName = ValidSheetName(opts.SheetName)
+	}
+
+	// Create a new Excel file, closing it to remove the stream writer's temporary files
+	f := excelize.NewFile()
+	defer f.Close()
+
+	// Get the default sheet name
+	defaultSheet := f.GetSheetName(0) // Usually "Sheet1"
+
+	// Convert the CSV content, to several sheets if it is split
+	if opts.RowsPerSheet > 0 {
+		sheetNames, err := convertReaderToSheets(ctx, r, f, sheetName, opts)
+		if err != nil {
+			return fmt.Errorf("conversion failed for %s: %w", csvPath, err)
+		}
+		sheetName = sheetNames[0]
+	} else if err := ConvertReader(ctx, r, f, sheetName, opts); err != nil {
+		return fmt.Errorf("conversion failed for %s: %w", csvPath, err)
+	}
+
+	// Set the active sheet
+	index, _ := f.GetSheetIndex(sheetName)
+	f.SetActiveSheet(index)
+
+	// Delete the default sheet after setting the active sheet, unless it holds the data
+	if sheetName != defaultSheet && !opts.KeepDefaultSheet {
+		f.DeleteSheet(defaultSheet)
+	}
+
+	// Describe the workbook, titled after the CSV file by default
+	defaultTitle := ""
+	if csvPath != StdinPath {
+		defaultTitle = filepath.Base(csvPath)
+	}
+	if err := SetProperties(f, defaultTitle, opts); err != nil {
+		return err
+	}
+
+	// Standard output has no file to replace
+	if xlsxPath == StdoutPath {
+		if err := f.Write(os.Stdout, excelize.Options{Password: opts.Password}); err != nil {
+			return fmt.Errorf("error writing to standard output: %v", err)
+		}
+		return nil
+	}
+
+	// Save atomically so an interrupted save never leaves a truncated file
+	if err := SaveAtomically(f, xlsxPath, excelize.Options{Password: opts.Password}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SetProperties writes the Title, Author and Company options to the
+// document properties of f, the title defaulting to defaultTitle; empty
+// values leave the properties of f unchanged
+func SetProperties(f *excelize.File, defaultTitle string, opts Options) error {
+	title := opts.Title
+	if title == "" {
+		title = defaultTitle
+	}
+	if err := f.SetDocProps(&excelize.DocProperties{Title: title, Creator: opts.Author}); err != nil {
+		return fmt.Errorf("error setting document properties: %v", err)
+	}
+
+	// The company is an application property, all written at once
+	if opts.Company != "" {
+		props, err := f.GetAppProps()
+		if err != nil {
+			return fmt.Errorf("error reading document properties: %v", err)
+		}
+		props.Company = opts.Company
+		if err := f.SetAppProps(props); err != nil {
+			return fmt.Errorf("error setting document properties: %v", err)
+		}
+	}
+	return nil
+}
+
+// ConvertReader converts CSV content read from r to a sheet of f, starting
+// at the first row; the sheet is created if missing
+func ConvertReader(ctx context.Context, r io.Reader, f *excelize.File, sheetName string, opts Options) error {
+	return ConvertReaderAt(ctx, r, f, sheetName, 1, opts)
+}
+
+// ConvertReaderAt converts CSV content read from r to a sheet of f starting
+// at startRow, creating the sheet if missing. Column widths are fitted to
+// the content; below existing rows they are only ever widened.
+// The conversion stops with the context error when ctx is canceled.
+func ConvertReaderAt(ctx context.Context, r io.Reader, f *excelize.File, sheetName string, startRow int, opts Options) error {
+	// Create the sheet if it is missing
+	index, err := f.GetSheetIndex(sheetName)
+	if err != nil {
+		return fmt.Errorf("invalid sheet name %s: %v", sheetName, err)
+	}
+	if index == -1 {
+		if _, err := f.NewSheet(sheetName); err != nil {
+			return fmt.Errorf("unable to create sheet %s: %v", sheetName, err)
+		}
+	}
+
+	// Column widths are set by the stream writer itself
+	if opts.Stream {
+		if startRow != 1 {
+			return fmt.Errorf("streaming can only write a sheet from its first row")
+		}
+		return streamReaderToSheet(ctx, r, f, sheetName, opts)
+	}
+
+	columnWidths, err := convertReaderToSheet(ctx, r, f, sheetName, startRow, opts)
+	if err != nil {
+		return err
+	}
+
+	// Below existing rows, never shrink the existing layout
+	if startRow > 1 {
+		for colIndex, width := range columnWidths {
+			colName, _ := excelize.ColumnNumberToName(colIndex + 1)
+			if existing, err := f.GetColWidth(sheetName, colName); err == nil && float64(width) < existing {
+				columnWidths[colIndex] = int(existing)
+			}
+		}
+	}
+
+	// Adjust column widths to fit content
+	adjustColumnWidths(f, sheetName, columnWidths, opts)
+
+	return nil
+}
+
+// Validate reads CSV content from r with the reader settings of a conversion,
+// without writing anything, and returns a description of each record whose
+// number of fields differs from the first record after the SkipLines
+// preamble, followed by the parse error that stopped the reading, if any.
+// The records read are counted in Options.Stats.
+func Validate(ctx context.Context, r io.Reader, opts Options) ([]string, error) {
+	counter := &countingReader{r: r}
+	reader, err := newCSVReader(counter, "", opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	rows, colCount := 0, 0
+	fields := 0 // Number of fields of the first record after the preamble
+	linesSkipped := 0
+	linesRead := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		// Parse errors end the reading
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("error reading CSV: %v", err)
+			}
+			problems = append(problems, fmt.Sprintf("line %d: %v", parseErr.Line, parseErr.Err))
+			break
+		}
+
+		// Lock the number of fields to the first record after the preamble
+		if linesSkipped == opts.SkipLines {
+			if fields == 0 {
+				fields = len(record)
+			} else if len(record) != fields {
+				line, _ := reader.FieldPos(0)
+				problems = append(problems, fmt.Sprintf("line %d: %d fields instead of %d", line, len(record), fields))
+			}
+		}
+
+		// Malformed text only fails a conversion with StrictUTF8
+		if opts.StrictUTF8 {
+			for i, value := range record {
+				if !utf8.ValidString(value) {
+					line, _ := reader.FieldPos(i)
+					problems = append(problems, fmt.Sprintf("line %d: invalid UTF-8 in field %d", line, i+1))
+					break
+				}
+			}
+		}
+
+		// Stop promptly when the caller cancels
+		linesRead++
+		if linesRead%cancelCheckRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		// Discard the preamble records
+		if linesSkipped < opts.SkipLines {
+			linesSkipped++
+			continue
+		}
+
+		rows++
+		colCount = max(colCount, len(record))
+	}
+
+	opts.addStats(rows, colCount, counter.n)
+	return problems, nil
+}
+
+// ReadHeader returns the record of CSV content read from r that a conversion
+// writes first (the header), after the SkipLines preamble and, with
+// SkipEmpty, blank records; it is nil for content without records
+func ReadHeader(r io.Reader, opts Options) ([]string, error) {
+	opts.Verbose = false
+	csvReader, err := newCSVReader(r, "", opts)
+	if err != nil {
+		return nil, err
+	}
+	reader := withAddedHeader(withTransposed(withValidUTF8(csvReader, opts), opts), &opts)
+
+	linesSkipped := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV: %v", err)
+		}
+		if linesSkipped < opts.SkipLines {
+			linesSkipped++
+			continue
+		}
+		if opts.SkipEmpty && isEmptyRecord(record) {
+			continue
+		}
+		return record, nil
+	}
+}
+
+// Convert CSV content read from r to sheets named baseName_1, baseName_2, ...
+// holding at most RowsPerSheet data rows each, with the header repeated at
+// the top of every sheet; return the names of the sheets created
+func convertReaderToSheets(ctx context.Context, r io.Reader, f *excelize.File, baseName string, opts Options) ([]string, error) {
+	// Count the bytes read for the caller's totals
+	counter := &countingReader{r: r}
+	csvReader, err := newCSVReader(counter, baseName, opts)
+	if err != nil {
+		return nil, err
+	}
+	reader := withAddedHeader(withTransposed(withValidUTF8(csvReader, opts), opts), &opts)
+
+	// Read the next record to distribute, dropping the preamble and,
+	// if requested, blank records
+	linesSkipped := 0
+	next := func() ([]string, error) {
+		for {
+			record, err := reader.Read()
+			if err != nil {
+				return nil, err
+			}
+			if linesSkipped < opts.SkipLines {
+				linesSkipped++
+				continue
+			}
+			if opts.SkipEmpty && isEmptyRecord(record) {
+				continue
+			}
+			return record, nil
+		}
+	}
+
+	// Read the header, then always one record ahead so that no sheet is
+	// created without data
+	header, readErr := next()
+	var record []string
+	if readErr == nil {
+		record, readErr = next()
+	}
+
+	// The records are filtered above and the MaxRows limit applies to the
+	// whole content; the header is passed to every sheet, which drops it
+	// again with SkipHeader
+	partOpts := opts
+	partOpts.SkipLines = 0
+	partOpts.SkipEmpty = false
+	partOpts.MaxRows = 0
+
+	var sheetNames []string
+	dataRows := 0
+	for part := 1; part == 1 || readErr == nil; part++ {
+		sheetName := suffixedSheetName(baseName, fmt.Sprintf("_%d", part))
+		if _, err := f.NewSheet(sheetName); err != nil {
+			return sheetNames, fmt.Errorf("unable to create sheet %s: %v", sheetName, err)
+		}
+
+		headerSent := header == nil
+		count := 0
+		partReader := recordFunc(func() ([]string, error) {
+			if !headerSent {
+				headerSent = true
+				return header, nil
+			}
+			if opts.MaxRows > 0 && dataRows+count == opts.MaxRows {
+				readErr = io.EOF
+			}
+			if readErr != nil {
+				return nil, readErr
+			}
+			if count == opts.RowsPerSheet {
+				return nil, io.EOF
+			}
+			current := record
+			count++
+			record, readErr = next()
+			return current, nil
+		})
+
+		columnWidths, err := convertRecordsToSheet(ctx, partReader, f, sheetName, 1, partOpts)
+		if err != nil {
+			return sheetNames, err
+		}
+		adjustColumnWidths(f, sheetName, columnWidths, opts)
+		sheetNames = append(sheetNames, sheetName)
+
+		if count > 0 {
+			opts.infof("Sheet '%s': data rows %d to %d", sheetName, dataRows+1, dataRows+count)
+		}
+		dataRows += count
+	}
+
+	opts.addStats(0, 0, counter.n)
+	return sheetNames, nil
+}
+
+// SaveAtomically saves the workbook without ever leaving a partially written
+// file behind; the options are passed to excelize, e.g. to set a password
+func SaveAtomically(f *excelize.File, xlsxFilePath string, opts ...excelize.Options) error {
+	// The extension of the path selects the workbook format, as with SaveAs
+	f.Path = xlsxFilePath
+	return WriteFileAtomically(xlsxFilePath, func(w io.Writer) error {
+		_, err := f.WriteTo(w, opts...)
+		return err
+	})
+}
+
+// WriteFileAtomically writes a file through a temporary file in the same
+// directory that is renamed over the target once complete
+func WriteFileAtomically(path string, write func(w io.Writer) error) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating temporary file: %v", err)
+	}
+	tmpPath := tmpFile.Name()
+
+	if err := write(tmpFile); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error writing %s: %v", path, err)
+	}
+
+	// Temporary files are created with mode 0600
+	os.Chmod(tmpPath, 0644)
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error saving %s: %v", path, err)
+	}
+
+	return nil
+}
+
+// OpenCSV opens a CSV file for reading, decompressing it on the fly when its
+// name ends in .gz; closing the returned reader closes the file
+func OpenCSV(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(strings.ToLower(path), ".gz") {
+		return file, nil
+	}
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("invalid gzip data: %v", err)
+	}
+	return &gzipFile{Reader: gzipReader, file: file}, nil
+}
+
+// Gzip-compressed CSV file opened by OpenCSV
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// TrimCSVExt strips the extension from the path of a CSV file, together
+// with the .gz suffix of compressed files (data.csv.gz becomes data)
+func TrimCSVExt(path string) string {
+	if strings.HasSuffix(strings.ToLower(path), ".gz") {
+		path = path[:len(path)-len(".gz")]
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path))
+}
+
+// DefaultSeparator returns the separator implied by the extension of a CSV
+// file: tab for .tsv files, 0 (detect it from the content) otherwise
+func DefaultSeparator(path string) rune {
+	name := strings.TrimSuffix(strings.ToLower(path), ".gz")
+	if filepath.Ext(name) == ".tsv" {
+		return '\t'
+	}
+	return 0
+}
+
+// Add the rows, columns written and bytes read to the caller's totals, if requested
+func (o Options) addStats(rows, columns int, bytes int64) {
+	if o.Stats != nil {
+		o.Stats.Rows += rows
+		o.Stats.Columns = max(o.Stats.Columns, columns)
+		o.Stats.Bytes += bytes
+	}
+}
+
+// Reader counting the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// MessageWriter returns the destination of the informational messages,
+// standard output unless Messages is set
+func (o Options) MessageWriter() io.Writer {
+	if o.Messages == nil {
+		return os.Stdout
+	}
+	return o.Messages
+}
+
+// Print an informational message, unless Quiet is set
+func (o Options) infof(format string, args ...interface{}) {
+	if !o.Quiet {
+		fmt.Fprintf(o.MessageWriter(), format+"\n", args...)
+	}
+}
+
+// Print a warning, also when Quiet is set
+func (o Options) warnf(format string, args ...interface{}) {
+	fmt.Fprintf(o.MessageWriter(), "Warning: "+format+"\n", args...)
+}
+
+// Print a diagnostic message, only when Verbose is set
+func (o Options) debugf(format string, args ...interface{}) {
+	if o.Verbose {
+		fmt.Fprintf(o.MessageWriter(), format+"\n", args...)
+	}
+}
+
+// Supported ISO-8601 duration subset: PnW, PnD and TnHnMnS components,
+// with an optional decimal fraction on any component (e.g. P1DT2H, PT1H30M, PT45.5S)
+var isoDurationPattern = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)W)?(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// Currency amounts such as $1,234.56, -€99,90 or 1.234,56 €
+var currencyPattern = regexp.MustCompile(`^(-?)(?:([$€£¥₹])\s?(-?)([0-9][0-9.,]*)|([0-9][0-9.,]*)\s?([$€£¥₹]))$`)
+
+// Amounts with dot decimals (1,234.56) and comma decimals (1.234,56)
+var (
+	dotDecimalPattern   = regexp.MustCompile(`^(?:\d{1,3}(?:,\d{3})+|\d+)(?:\.\d+)?$`)
+	commaDecimalPattern = regexp.MustCompile(`^(?:\d{1,3}(?:\.\d{3})+|\d+)(?:,\d+)?$`)
+)
+
+// Currency pattern shared by all the values of a column
+type currencyColumn struct {
+	symbol       string
+	suffix       bool // The symbol follows the amount
+	dotDecimal   bool // All amounts are valid with a dot decimal separator
+	commaDecimal bool // All amounts are valid with a comma decimal separator
+	mismatch     bool // The column cannot be converted
+}
+
+// A single currency amount split into its parts
+type currencyMatch struct {
+	symbol       string
+	suffix       bool
+	negative     bool
+	amount       string
+	dotDecimal   bool
+	commaDecimal bool
+}
+
+// Whole-value web addresses such as https://example.com/a?b=1, turned into
+// links by Options.Hyperlinks; text around the URL or spaces keep it text
+var urlPattern = regexp.MustCompile(`^https?://[A-Za-z0-9](?:[A-Za-z0-9.-]*[A-Za-z0-9])?(?::\d+)?(?:[/?#][^\s"<>]*)?$`)
+
+// Excel limits on hyperlinks: per worksheet and length of the address
+const (
+	maxSheetHyperlinks = 65530
+	maxHyperlinkLength = 2079
+)
+
+// Identifiers with leading zeros such as 00123
+var leadingZeroPattern = regexp.MustCompile(`^0\d+$`)
+
+// Plain decimal numbers such as 42, -3.5, .5 or 1e6 (no hex, Inf or NaN)
+var numberPattern = regexp.MustCompile(`^[+-]?(?:\d+\.?\d*|\.\d+)(?:[eE][+-]?\d+)?$`)
+
+// Numbers with a decimal comma and optional dot thousands separators, such
+// as 1.234,56, 12,5 or 1.000
+var decimalCommaPattern = regexp.MustCompile(`^[+-]?(?:\d{1,3}(?:\.\d{3})+|\d+)(?:,\d+)?$`)
+
+// Candidate separators for detection, in order of preference on ties
+var delimiterCandidates = []rune{';', ',', '\t', '|'}
+
+// Number of non-empty lines and bytes inspected when detecting the separator
+const (
+	delimiterSniffLines = 10
+	delimiterSniffSize  = 64 * 1024
+)
+
+// Detect the separator by peeking at the first non-empty lines without
+// consuming them: the candidate giving the same field count (more than
+// one) on every line wins, preferring the highest field count. Falls back
+// to semicolon when the sample is too short or no candidate is consistent.
+func detectDelimiter(r *bufio.Reader) rune {
+	sample, err := r.Peek(delimiterSniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return ';'
+	}
+
+	// Drop the incomplete last line of a full buffer
+	if err == bufio.ErrBufferFull {
+		if i := bytes.LastIndexByte(sample, '\n'); i >= 0 {
+			sample = sample[:i]
+		}
+	}
+
+	// Collect the sample lines
+	var lines []string
+	for _, line := range strings.Split(string(sample), "\n") {
+		if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+		if len(lines) == delimiterSniffLines {
+			break
+		}
+	}
+	if len(lines) < 2 {
+		return ';'
+	}
+
+	best, bestFields := ';', 1
+	for _, candidate := range delimiterCandidates {
+		reader := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+		reader.Comma = candidate
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+
+		// Every line must produce the same number of fields
+		fields := -1
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil || (fields != -1 && len(record) != fields) {
+				fields = -1
+				break
+			}
+			fields = len(record)
+		}
+
+		if fields > bestFields {
+			best, bestFields = candidate, fields
+		}
+	}
+
+	return best
+}
+
+// Inspect the byte order mark of r and return a reader producing UTF-8
+// without the BOM, together with the name of the detected encoding
+func decodeBOM(r io.Reader) (io.Reader, string, error) {
+	bufReader := bufio.NewReader(r)
+	head, err := bufReader.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte{0xEF, 0xBB, 0xBF}):
+		bufReader.Discard(3)
+		return bufReader, "UTF-8 (BOM)", nil
+	case bytes.HasPrefix(head, []byte{0xFF, 0xFE}):
+		decoder := unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder()
+		return transform.NewReader(bufReader, decoder), "UTF-16LE", nil
+	case bytes.HasPrefix(head, []byte{0xFE, 0xFF}):
+		decoder := unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder()
+		return transform.NewReader(bufReader, decoder), "UTF-16BE", nil
+	}
+
+	return bufReader, "UTF-8", nil
+}
+
+// Convert CSV content read from r to an Excel sheet starting at startRow and return column widths
+func convertReaderToSheet(ctx context.Context, r io.Reader, f *excelize.File, sheetName string, startRow int, opts Options) (map[int]int, error) {
+	// Count the bytes read for the caller's totals
+	counter := &countingReader{r: r}
+	csvReader, err := newCSVReader(counter, sheetName, opts)
+	if err != nil {
+		return nil, err
+	}
+	reader := withAddedHeader(withTransposed(withValidUTF8(csvReader, opts), opts), &opts)
+
+	columnWidths, err := convertRecordsToSheet(ctx, reader, f, sheetName, startRow, opts)
+	if err != nil {
+		return nil, err
+	}
+	opts.addStats(0, 0, counter.n)
+	return columnWidths, nil
+}
+
+// Source of CSV records, such as a csv.Reader
+type recordReader interface {
+	Read() ([]string, error)
+}
+
+// Function returning CSV records, used as a recordReader
+type recordFunc func() ([]string, error)
+
+func (fn recordFunc) Read() ([]string, error) {
+	return fn()
+}
+
+// Return reader with rows and columns swapped for Transpose: field j of
+// record i becomes field i of row j. The first call reads all the records
+func withTransposed(reader recordReader, opts Options) recordReader {
+	if !opts.Transpose {
+		return reader
+	}
+
+	var rows [][]string
+	read := false
+	return recordFunc(func() ([]string, error) {
+		if !read {
+			read = true
+			var records [][]string
+			for {
+				record, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				records = append(records, record)
+			}
+			if len(records) > excelize.MaxColumns {
+				return nil, fmt.Errorf("%d records cannot be transposed, a sheet has at most %d columns", len(records), excelize.MaxColumns)
+			}
+			rows = transpose(records)
+		}
+
+		if len(rows) == 0 {
+			return nil, io.EOF
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row, nil
+	})
+}
+
+// Swap the rows and columns of records; a row ends with the last record
+// long enough to have a field for it
+func transpose(records [][]string) [][]string {
+	width := 0
+	for _, record := range records {
+		width = max(width, len(record))
+	}
+
+	rows := make([][]string, width)
+	for j := range rows {
+		for i, record := range records {
+			if j < len(record) {
+				rows[j] = append(rows[j], make([]string, i-len(rows[j]))...)
+				rows[j] = append(rows[j], record[j])
+			}
+		}
+	}
+	return rows
+}
+
+// Return reader with the AddHeader titles inserted after the SkipLines
+// preamble, replacing the first CSV record with SkipHeader; opts no longer
+// asks to skip the header, the returned reader does it. Records with more
+// fields than titles are an error
+func withAddedHeader(reader recordReader, opts *Options) recordReader {
+	header := opts.AddHeader
+	if header == nil {
+		return reader
+	}
+	skipHeader, skipLines := opts.SkipHeader, opts.SkipLines
+	opts.SkipHeader = false
+
+	linesPassed := 0
+	headerSent := false
+	return recordFunc(func() ([]string, error) {
+		// The preamble is left to the caller to discard
+		if linesPassed < skipLines {
+			linesPassed++
+			return reader.Read()
+		}
+		if !headerSent {
+			headerSent = true
+			if skipHeader {
+				if _, err := reader.Read(); err != nil && err != io.EOF {
+					return nil, err
+				}
+			}
+			return header, nil
+		}
+
+		record, err := reader.Read()
+		if err == nil && len(record) > len(header) {
+			return nil, fmt.Errorf("the row has %d fields but only %d header titles were given", len(record), len(header))
+		}
+		return record, err
+	})
+}
+
+// Return reader with the invalid UTF-8 bytes of every field replaced by
+// U+FFFD, or, with StrictUTF8, failing on the first field holding them
+func withValidUTF8(reader fieldReader, opts Options) recordReader {
+	return recordFunc(func() ([]string, error) {
+		record, err := reader.Read()
+		for i, value := range record {
+			if utf8.ValidString(value) {
+				continue
+			}
+			if opts.StrictUTF8 {
+				line, _ := reader.FieldPos(i)
+				return nil, fmt.Errorf("line %d: invalid UTF-8 in field %d", line, i+1)
+			}
+			record[i] = strings.ToValidUTF8(value, "\uFFFD")
+		}
+		return record, err
+	})
+}
+
+// Convert the records of reader to an Excel sheet starting at startRow and return column widths
+func convertRecordsToSheet(ctx context.Context, reader recordReader, f *excelize.File, sheetName string, startRow int, opts Options) (map[int]int, error) {
+	// Map to track the maximum width of each column
+	columnWidths := make(map[int]int)
+
+	// Read and process the CSV row by row
+	rowIndex := startRow
+
+	// Reserve the rows above the data for the timestamp cell
+	if opts.UpdatedCell != "" {
+		reservedRows, err := writeUpdatedCell(f, sheetName, opts, columnWidths)
+		if err != nil {
+			return nil, err
+		}
+		rowIndex = reservedRows + 1
+	}
+
+	// Reserve two rows for the summary panel labels and formulas
+	panelRow := 0
+	if opts.SummaryCol > 0 {
+		panelRow = rowIndex
+		rowIndex += 2
+	}
+	firstDataRow := rowIndex
+
+	// Keep the reserved rows visible while scrolling through the data
+	if firstDataRow > startRow {
+		if err := freezePanes(f, sheetName, firstDataRow-1, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	// Columns whose values so far are all ISO-8601 durations (absent = no values yet)
+	durationCols := make(map[int]bool)
+
+	// Columns whose values so far are all boolean tokens (absent = no values yet)
+	boolCols := make(map[int]bool)
+
+	// Currency pattern of each column (absent = no values yet)
+	currencyCols := make(map[int]*currencyColumn)
+
+	// Columns whose values so far are all percentages (absent = no values yet)
+	percentCols := make(map[int]bool)
+
+	// Columns whose values so far are all numbers (absent = no values yet),
+	// and those with a fractional number among them
+	numericCols := make(map[int]bool)
+	floatCols := make(map[int]bool)
+
+	// Number of numeric or date values and of text values of each column,
+	// for the alignment by type
+	typedCounts := make(map[int]int)
+	textCounts := make(map[int]int)
+
+	// Styles of the numbers read with a decimal comma
+	groupStyles := make(groupingStyles)
+
+	// Text ("@") style protecting identifiers with leading zeros, created on first use
+	textStyle := -1
+
+	// Link style of the URLs, the style created on first use, number of links
+	// and whether reaching the limit was reported
+	linkStyle := -1
+	linkCount := 0
+	linkLimitWarned := false
+
+	// Cells holding line breaks, wrapped with WrapLines once all the other
+	// styles are set
+	var multilineCells [][2]int
+
+	// Date style for values converted with DateLayouts, created on first use
+	dateStyle := -1
+	headerSkipped := false
+	linesSkipped := 0
+	linesRead := 0
+
+	// Widest record written, giving the last used data column
+	colCount := 0
+
+	// First two records written, telling whether the first is a header
+	var firstRecords [][]string
+
+	// Length of each record written, to pad the short ones at the end
+	var recordLengths []int
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV at row %d: %v", rowIndex, err)
+		}
+
+		// Stop promptly when the caller cancels
+		linesRead++
+		if linesRead%cancelCheckRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		// Discard the preamble records
+		if linesSkipped < opts.SkipLines {
+			linesSkipped++
+			continue
+		}
+
+		// Drop blank separator rows without leaving a gap
+		if opts.SkipEmpty && isEmptyRecord(record) {
+			continue
+		}
+
+		// Drop the CSV header if requested
+		if opts.SkipHeader && !headerSkipped {
+			headerSkipped = true
+			continue
+		}
+
+		// Keep only the selected columns, in their order
+		if opts.Columns != nil {
+			first := rowIndex == firstDataRow
+			if record, err = selectColumns(record, opts.Columns, first, first && !opts.SkipHeader); err != nil {
+				return nil, err
+			}
+		}
+
+		// Identify the source of the row in the first column
+		if opts.SourceColumn != "" {
+			record = withSource(record, opts, rowIndex == firstDataRow && !opts.SkipHeader)
+		}
+
+		// Keep the values within the cell length limit
+		record = opts.limitCellLength(record, sheetName, rowIndex)
+
+		if len(record) > colCount {
+			colCount = len(record)
+		}
+		if len(firstRecords) < 2 {
+			firstRecords = append(firstRecords, record)
+		}
+
+		// Insert data into the Excel sheet
+		for colIndex, value := range record {
+			// Remove quotes at the beginning and end
+			if !opts.KeepQuotes && !opts.splitsLines() {
+				value = trimQuotes(value)
+			}
+
+			// Remove the padding around the value
+			if opts.Trim {
+				value = strings.TrimSpace(value)
+			}
+
+			// Columns with a forced type are left out of the detections below
+			forcedType := opts.ColumnTypes[colIndex+1]
+
+			// Track whether the column still consists of durations only,
+			// ignoring a non-matching first row (the header)
+			if opts.Durations && value != "" && forcedType == TypeAuto {
+				_, ok := parseISODuration(value)
+				if isDuration, seen := durationCols[colIndex]; seen {
+					durationCols[colIndex] = isDuration && ok
+				} else if ok || rowIndex != firstDataRow {
+					durationCols[colIndex] = ok
+				}
+			}
+
+			// Track whether the column still consists of boolean tokens only,
+			// ignoring a non-matching first row (the header)
+			if opts.Bools && value != "" && forcedType == TypeAuto {
+				_, ok := opts.parseBool(value)
+				if isBool, seen := boolCols[colIndex]; seen {
+					boolCols[colIndex] = isBool && ok
+				} else if ok || rowIndex != firstDataRow {
+					boolCols[colIndex] = ok
+				}
+			}
+
+			// Track whether the column still uses a single currency pattern
+			if opts.Currency && value != "" && forcedType == TypeAuto {
+				match, ok := matchCurrency(value)
+				if cc, seen := currencyCols[colIndex]; seen {
+					if !ok || match.symbol != cc.symbol || match.suffix != cc.suffix {
+						cc.mismatch = true
+					} else {
+						cc.dotDecimal = cc.dotDecimal && match.dotDecimal
+						cc.commaDecimal = cc.commaDecimal && match.commaDecimal
+						cc.mismatch = cc.mismatch || (!cc.dotDecimal && !cc.commaDecimal)
+					}
+				} else if ok {
+					currencyCols[colIndex] = &currencyColumn{
+						symbol:       match.symbol,
+						suffix:       match.suffix,
+						dotDecimal:   match.dotDecimal,
+						commaDecimal: match.commaDecimal,
+					}
+				} else if rowIndex != firstDataRow {
+					currencyCols[colIndex] = &currencyColumn{mismatch: true}
+				}
+			}
+
+			// Track whether the column still consists of percentages only,
+			// ignoring a non-matching first row (the header)
+			if opts.Percent && value != "" && forcedType == TypeAuto {
+				_, ok := parsePercent(value, opts)
+				if isPercent, seen := percentCols[colIndex]; seen {
+					percentCols[colIndex] = isPercent && ok
+				} else if ok || rowIndex != firstDataRow {
+					percentCols[colIndex] = ok
+				}
+			}
+
+			// Convert indices to cell name (A1, B1, etc.)
+			cellName, err := excelize.CoordinatesToCellName(colIndex+1, rowIndex)
+			if err != nil {
+				return nil, fmt.Errorf("error converting coordinates: %v", err)
+			}
+
+			// URLs stay text, whatever else they may look like
+			isLink := opts.Hyperlinks && len(value) <= maxHyperlinkLength && urlPattern.MatchString(value)
+			if isLink && linkCount == maxSheetHyperlinks && !linkLimitWarned {
+				opts.warnf("sheet '%s' reached the limit of %d hyperlinks, further URLs are kept as text", sheetName, maxSheetHyperlinks)
+				linkLimitWarned = true
+			}
+			isLink = isLink && linkCount < maxSheetHyperlinks
+
+			// Set the value in the cell, as a date or number when it is one.
+			// Cells are written one by one: SetSheetRow only loops over
+			// SetCellValue through reflection and is no faster (see
+			// BenchmarkSetCells), the fast path for large files is Stream
+			var cellValue interface{} = value
+			isDate := false
+			if !isLink {
+				cellValue, isDate = typedValue(value, colIndex, opts)
+			}
+			if err := f.SetCellValue(sheetName, cellName, cellValue); err != nil {
+				return nil, fmt.Errorf("error setting cell value: %v", err)
+			}
+
+			// Link the URL, displayed as the URL itself in the usual link style
+			if isLink {
+				if err := f.SetCellHyperLink(sheetName, cellName, value, "External"); err != nil {
+					return nil, fmt.Errorf("error setting hyperlink: %v", err)
+				}
+				if linkStyle == -1 {
+					if linkStyle, err = f.NewStyle(&excelize.Style{Font: &excelize.Font{Color: "#0563C1", Underline: "single"}}); err != nil {
+						return nil, fmt.Errorf("error creating link style: %v", err)
+					}
+				}
+				if err := f.SetCellStyle(sheetName, cellName, cellName, linkStyle); err != nil {
+					return nil, fmt.Errorf("error setting cell style: %v", err)
+				}
+				linkCount++
+			}
+
+			// Track whether the column still holds numbers only, ignoring
+			// a non-numeric first row (the header)
+			if (opts.NumFormat != "" || opts.FloatDecimals > 0 || opts.Heatmap) && value != "" {
+				_, isText := cellValue.(string)
+				isNumber := !isText && !isDate
+				if numeric, seen := numericCols[colIndex]; seen {
+					numericCols[colIndex] = numeric && isNumber
+				} else if isNumber || rowIndex != firstDataRow {
+					numericCols[colIndex] = isNumber
+				}
+				if _, isFloat := cellValue.(float64); isFloat {
+					floatCols[colIndex] = true
+				}
+			}
+
+			// Count the types of the column, leaving out the header
+			if opts.AlignByType && value != "" && (rowIndex != firstDataRow || opts.SkipHeader) {
+				if _, isText := cellValue.(string); isText && !isDate {
+					textCounts[colIndex]++
+				} else {
+					typedCounts[colIndex]++
+				}
+			}
+
+			// Display dates with the configured format
+			if isDate {
+				if dateStyle == -1 {
+					dateFormat := opts.DateFormat
+					if dateStyle, err = f.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat}); err != nil {
+						return nil, fmt.Errorf("error creating date style: %v", err)
+					}
+				}
+				if err := f.SetCellStyle(sheetName, cellName, cellName, dateStyle); err != nil {
+					return nil, fmt.Errorf("error setting cell style: %v", err)
+				}
+			}
+
+			// Group the digits of numbers written with separators
+			if _, isText := cellValue.(string); opts.DecimalComma && !isText && !isDate {
+				styleID, err := groupStyles.forValue(f, value)
+				if err != nil {
+					return nil, err
+				}
+				if styleID != 0 {
+					if err := f.SetCellStyle(sheetName, cellName, cellName, styleID); err != nil {
+						return nil, fmt.Errorf("error setting cell style: %v", err)
+					}
+				}
+			}
+
+			// Mark identifiers with leading zeros, and forced text, as text so
+			// Excel never drops the zeros
+			if _, isText := cellValue.(string); isText && (leadingZeroPattern.MatchString(value) || forcedType == TypeText) {
+				if textStyle == -1 {
+					if textStyle, err = f.NewStyle(&excelize.Style{NumFmt: 49}); err != nil {
+						return nil, fmt.Errorf("error creating text style: %v", err)
+					}
+				}
+				if err := f.SetCellStyle(sheetName, cellName, cellName, textStyle); err != nil {
+					return nil, fmt.Errorf("error setting cell style: %v", err)
+				}
+			}
+
+			// Update the maximum width for this column
+			opts.trackWidth(columnWidths, colIndex, value)
+			if opts.WrapLines && strings.Contains(value, "\n") {
+				multilineCells = append(multilineCells, [2]int{colIndex + 1, rowIndex})
+			}
+		}
+		rowIndex++
+		if opts.Pad {
+			recordLengths = append(recordLengths, len(record))
+		}
+
+		// In template mode the first row is the header and nothing else is written
+		if opts.HeaderOnly {
+			if err := styleHeaderRow(f, sheetName, rowIndex-1, len(record)); err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		// Stop reading once the row limit is reached
+		if maxRowsReached(rowIndex-firstDataRow, opts) {
+			opts.infof("Sheet '%s': stopped after %d data rows (-max-rows)", sheetName, opts.MaxRows)
+			break
+		}
+	}
+
+	// Refuse content without data rows, or mark the new sheet as empty
+	if opts.noData(rowIndex - firstDataRow) {
+		if opts.SkipEmptyFiles {
+			return nil, ErrNoData
+		}
+		if startRow == 1 {
+			cellName, _ := excelize.CoordinatesToCellName(1, rowIndex)
+			if err := f.SetCellStr(sheetName, cellName, emptyMarker); err != nil {
+				return nil, fmt.Errorf("error setting cell value: %v", err)
+			}
+			opts.trackWidth(columnWidths, 0, emptyMarker)
+		}
+	}
+
+	// Rewrite the duration columns as time values
+	for colIndex, isDuration := range durationCols {
+		if isDuration {
+			if err := convertDurationColumn(f, sheetName, colIndex, firstDataRow, rowIndex-1); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Rewrite the boolean columns as Excel booleans
+	for colIndex, isBool := range boolCols {
+		if isBool {
+			if err := convertBoolColumn(f, sheetName, colIndex, firstDataRow, rowIndex-1, opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Rewrite the currency columns as formatted numbers
+	for colIndex, cc := range currencyCols {
+		if !cc.mismatch {
+			if err := convertCurrencyColumn(f, sheetName, colIndex, firstDataRow, rowIndex-1, cc); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Rewrite the percentage columns as fractions in a percent format
+	for colIndex, isPercent := range percentCols {
+		if isPercent {
+			if err := convertPercentColumn(f, sheetName, colIndex, firstDataRow, rowIndex-1, opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Display the numeric columns with the configured number format, or
+	// the fractional ones with the configured decimals
+	numFormat := opts.NumFormat
+	if numFormat == "" && opts.FloatDecimals > 0 {
+		numFormat = "0." + strings.Repeat("0", opts.FloatDecimals)
+	}
+	for _, colIndex := range slices.Sorted(maps.Keys(numericCols)) {
+		if numFormat == "" || !numericCols[colIndex] || (opts.NumFormat == "" && !floatCols[colIndex]) {
+			continue
+		}
+		err := updateRangeStyle(f, sheetName, colIndex+1, firstDataRow, colIndex+1, rowIndex-1, func(style *excelize.Style) {
+			style.CustomNumFmt = &numFormat
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Align each column by its dominant type, numbers and dates (including
+	// the converted currencies, durations and percentages) right and text left, leaving
+	// the header and the boolean columns with the default alignment
+	if opts.AlignByType {
+		firstAlignRow := firstDataRow
+		if !opts.SkipHeader {
+			firstAlignRow++
+		}
+		for colIndex := range colCount {
+			if boolCols[colIndex] || typedCounts[colIndex]+textCounts[colIndex] == 0 {
+				continue
+			}
+			cc := currencyCols[colIndex]
+			alignment := "left"
+			if typedCounts[colIndex] > textCounts[colIndex] || durationCols[colIndex] || percentCols[colIndex] || (cc != nil && !cc.mismatch) {
+				alignment = "right"
+			}
+			err := updateRangeStyle(f, sheetName, colIndex+1, firstAlignRow, colIndex+1, rowIndex-1, func(style *excelize.Style) {
+				if style.Alignment == nil {
+					style.Alignment = &excelize.Alignment{}
+				}
+				style.Alignment.Horizontal = alignment
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Write the summary panel formulas over the final data range
+	if panelRow > 0 {
+		if opts.SummaryCol > colCount {
+			return nil, fmt.Errorf("cannot summarize column %d: the sheet has %d", opts.SummaryCol, colCount)
+		}
+		if _, err := convertNumericColumn(f, sheetName, opts.SummaryCol-1, firstDataRow, rowIndex-1, opts); err != nil {
+			return nil, err
+		}
+		if err := writeSummaryPanel(f, sheetName, opts.SummaryCol, panelRow, firstDataRow, rowIndex-1, columnWidths); err != nil {
+			return nil, err
+		}
+	}
+
+	// Apply the color scale to the chosen column, below the header
+	if opts.ColorScaleCol > 0 {
+		firstScaleRow := firstDataRow
+		if !opts.SkipHeader {
+			firstScaleRow++
+		}
+		if err := applyColorScale(f, sheetName, opts, firstScaleRow, rowIndex-1); err != nil {
+			return nil, err
+		}
+	}
+
+	// Color the numeric columns other than the chosen one, below the header
+	if opts.Heatmap {
+		firstHeatmapRow := firstDataRow
+		if !opts.SkipHeader {
+			firstHeatmapRow++
+		}
+		for _, colIndex := range slices.Sorted(maps.Keys(numericCols)) {
+			if !numericCols[colIndex] || colIndex+1 == opts.ColorScaleCol || firstHeatmapRow > rowIndex-1 {
+				continue
+			}
+			startCell, _ := excelize.CoordinatesToCellName(colIndex+1, firstHeatmapRow)
+			endCell, _ := excelize.CoordinatesToCellName(colIndex+1, rowIndex-1)
+			if err := f.SetConditionalFormat(sheetName, startCell+":"+endCell, []excelize.ConditionalFormatOptions{colorScaleFormat(opts)}); err != nil {
+				return nil, fmt.Errorf("error setting color scale: %v", err)
+			}
+		}
+	}
+
+	// Make the data rectangular, now that the widest record is known
+	for i, length := range recordLengths {
+		for colIndex := length; colIndex < colCount; colIndex++ {
+			cellName, _ := excelize.CoordinatesToCellName(colIndex+1, firstDataRow+i)
+			if err := f.SetCellStr(sheetName, cellName, ""); err != nil {
+				return nil, fmt.Errorf("error padding row %d: %v", firstDataRow+i, err)
+			}
+		}
+	}
+
+	// Bold the header of a new sheet, to be frozen with the reserved rows
+	frozenRows := 0
+	if firstDataRow > startRow {
+		frozenRows = firstDataRow - 1
+	}
+	header := startRow == 1 && opts.hasHeader(sheetName, firstRecords)
+	if header {
+		err := updateRangeStyle(f, sheetName, 1, firstDataRow, colCount, firstDataRow, func(style *excelize.Style) {
+			if style.Font == nil {
+				style.Font = &excelize.Font{}
+			}
+			style.Font.Bold = true
+		})
+		if err != nil {
+			return nil, err
+		}
+		frozenRows = firstDataRow
+	}
+
+	// Freeze the header and the leftmost columns, together with the
+	// reserved rows; an empty sheet has nothing to freeze
+	if (header || opts.FreezeCols > 0) && colCount > 0 {
+		if opts.FreezeCols > colCount {
+			return nil, fmt.Errorf("cannot freeze %d columns: the sheet has %d", opts.FreezeCols, colCount)
+		}
+		if err := freezePanes(f, sheetName, frozenRows, opts.FreezeCols); err != nil {
+			return nil, err
+		}
+	}
+
+	opts.debugf("Sheet '%s': %d rows, %d columns", sheetName, rowIndex-firstDataRow, colCount)
+
+	// Add the rows written to the caller's totals
+	opts.addStats(rowIndex-firstDataRow, colCount, 0)
+
+	// Add the autofilter over the header and data, if anything was written
+	if opts.AutoFilter && colCount > 0 && rowIndex > firstDataRow {
+		startCell, _ := excelize.CoordinatesToCellName(1, firstDataRow)
+		endCell, _ := excelize.CoordinatesToCellName(colCount, rowIndex-1)
+		if err := f.AutoFilter(sheetName, startCell+":"+endCell, nil); err != nil {
+			return nil, fmt.Errorf("error setting autofilter: %v", err)
+		}
+	}
+
+	// Wrap the text of the columns too wide for the maximum width, which
+	// then keep that width while Excel fits the row heights to the text
+	if opts.Wrap {
+		_, maxWidth := opts.widthLimits()
+		for colIndex, width := range columnWidths {
+			if _, fixed := opts.ColWidths[colIndex+1]; width <= maxWidth || fixed {
+				continue
+			}
+			err := updateRangeStyle(f, sheetName, colIndex+1, firstDataRow, colIndex+1, rowIndex-1, func(style *excelize.Style) {
+				if style.Alignment == nil {
+					style.Alignment = &excelize.Alignment{}
+				}
+				style.Alignment.WrapText = true
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Show the line breaks of the multiline cells
+	for _, cell := range multilineCells {
+		err := updateRangeStyle(f, sheetName, cell[0], cell[1], cell[0], cell[1], func(style *excelize.Style) {
+			if style.Alignment == nil {
+				style.Alignment = &excelize.Alignment{}
+			}
+			style.Alignment.WrapText = true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Register the header and data as a table; excelize names empty and
+	// duplicate header cells ColumnN and extends a lone header by one row
+	if opts.Table && colCount > 0 && rowIndex > firstDataRow {
+		startCell, _ := excelize.CoordinatesToCellName(1, firstDataRow)
+		endCell, _ := excelize.CoordinatesToCellName(colCount, rowIndex-1)
+		err := f.AddTable(sheetName, &excelize.Table{
+			Range:          startCell + ":" + endCell,
+			StyleName:      tableStyle,
+			ShowRowStripes: boolPtr(true),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error adding table: %v", err)
+		}
+	}
+
+	// Shade every other data row, leaving the header unshaded
+	if opts.Zebra {
+		firstZebraRow := firstDataRow
+		if !opts.SkipHeader {
+			firstZebraRow++
+		}
+		if err := applyZebraStripes(f, sheetName, firstZebraRow, rowIndex-1, usedColumnCount(columnWidths)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Shade the groups of the key column, leaving the header unshaded
+	if opts.GroupStripeCol > 0 {
+		firstGroupRow := firstDataRow
+		if !opts.SkipHeader {
+			firstGroupRow++
+		}
+		if err := applyGroupStripes(f, sheetName, opts.GroupStripeCol, firstGroupRow, rowIndex-1, usedColumnCount(columnWidths)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Set the font last, over the styles of all the passes above
+	if lastCol := max(colCount, usedColumnCount(columnWidths)); opts.customFont() && lastCol > 0 && rowIndex > startRow {
+		if err := updateRangeStyle(f, sheetName, 1, startRow, lastCol, rowIndex-1, opts.applyFont); err != nil {
+			return nil, err
+		}
+	}
+
+	return columnWidths, nil
+}
+
+// Report whether Font or FontSize replace the workbook default font
+func (o Options) customFont() bool {
+	return o.Font != "" || o.FontSize > 0
+}
+
+// Set the font family and size of a style to Font and FontSize, keeping
+// its other font settings (bold header, link color, ...)
+func (o Options) applyFont(style *excelize.Style) {
+	if style.Font == nil {
+		style.Font = &excelize.Font{}
+	}
+	if o.Font != "" {
+		style.Font.Family = o.Font
+	}
+	if o.FontSize > 0 {
+		style.Font.Size = o.FontSize
+	}
+}
+
+// fieldReader reads records and locates their fields in the input, like
+// csv.Reader and, for Raw, rawReader
+type fieldReader interface {
+	recordReader
+	FieldPos(field int) (line, column int)
+}
+
+// Report whether the lines are split without CSV quote processing
+func (o Options) splitsLines() bool {
+	return o.Raw || o.SeparatorLiteral != "" || o.SeparatorRegexp != nil
+}
+
+// Longest line a Raw reader accepts
+const rawMaxLineSize = 64 << 20
+
+// rawReader splits every line on the separator with no quote processing, so
+// that the text between two separators reaches the cell byte for byte; like
+// csv.Reader it skips blank and comment lines
+type rawReader struct {
+	scanner *bufio.Scanner
+	split   func(line string) (record []string, columns []int)
+	comment rune
+	line    int   // Line of the last record
+	columns []int // 1-based byte column of each field of the last record
+}
+
+func newRawReader(r io.Reader, opts Options) *rawReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, rawMaxLineSize)
+	reader := &rawReader{scanner: scanner, comment: opts.Comment}
+	switch {
+	case opts.SeparatorRegexp != nil:
+		reader.split = func(line string) ([]string, []int) {
+			return splitRegexp(line, opts.SeparatorRegexp)
+		}
+	case opts.SeparatorLiteral != "":
+		reader.split = func(line string) ([]string, []int) {
+			return splitLiteral(line, opts.SeparatorLiteral)
+		}
+	default:
+		reader.split = func(line string) ([]string, []int) {
+			return splitLiteral(line, string(opts.Separator))
+		}
+	}
+	return reader
+}
+
+// Split line at every occurrence of separator, returning the fields and
+// their 1-based byte columns
+func splitLiteral(line, separator string) ([]string, []int) {
+	record := strings.Split(line, separator)
+	columns := make([]int, len(record))
+	column := 1
+	for i, value := range record {
+		columns[i] = column
+		column += len(value) + len(separator)
+	}
+	return record, columns
+}
+
+// Split line at every match of re, returning the fields and their 1-based
+// byte columns
+func splitRegexp(line string, re *regexp.Regexp) ([]string, []int) {
+	var record []string
+	var columns []int
+	start := 0
+	for _, match := range re.FindAllStringIndex(line, -1) {
+		record = append(record, line[start:match[0]])
+		columns = append(columns, start+1)
+		start = match[1]
+	}
+	return append(record, line[start:]), append(columns, start+1)
+}
+
+func (r *rawReader) Read() ([]string, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := r.scanner.Text()
+		if line == "" || (r.comment != 0 && strings.HasPrefix(line, string(r.comment))) {
+			continue
+		}
+
+		var record []string
+		record, r.columns = r.split(line)
+		return record, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("line %d: %w", r.line+1, err)
+	}
+	return nil, io.EOF
+}
+
+func (r *rawReader) FieldPos(field int) (line, column int) {
+	return r.line, r.columns[field]
+}
+
+// Set up a CSV reader on r: decode according to the BOM or the configured
+// encoding and detect the separator when it is not configured
+func newCSVReader(r io.Reader, sheetName string, opts Options) (fieldReader, error) {
+	// Strip or decode according to the byte order mark
+	r, encodingName, err := decodeBOM(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CSV: %v", err)
+	}
+
+	// Without BOM, decode the configured encoding
+	if encodingName == "UTF-8" && opts.Encoding != nil {
+		r = transform.NewReader(r, opts.Encoding.NewDecoder())
+		encodingName = fmt.Sprint(opts.Encoding)
+	}
+	opts.debugf("Sheet '%s': detected encoding %s", sheetName, encodingName)
+
+	// Detect the separator from the first lines when not configured
+	if opts.Separator == 0 && opts.SeparatorLiteral == "" && opts.SeparatorRegexp == nil {
+		bufReader := bufio.NewReaderSize(r, delimiterSniffSize)
+		opts.Separator = detectDelimiter(bufReader)
+		r = bufReader
+		opts.debugf("Sheet '%s': detected separator %q", sheetName, opts.Separator)
+	}
+
+	// A detected separator may clash with the comment character
+	if opts.Comment != 0 && opts.Comment == opts.Separator {
+		return nil, fmt.Errorf("comment character %q is also the separator", opts.Comment)
+	}
+
+	// Split the lines as they are for Raw and the separators of several characters
+	if opts.splitsLines() {
+		return newRawReader(r, opts), nil
+	}
+
+	// Create a new CSV reader with appropriate settings
+	reader := csv.NewReader(r)
+	reader.Comma = opts.Separator  // Set the configured separator
+	reader.FieldsPerRecord = -1    // Allow variable number of fields per row
+	reader.LazyQuotes = true       // Handle quotes more flexibly
+	reader.TrimLeadingSpace = true // Remove leading spaces
+	reader.Comment = opts.Comment
+
+	return reader, nil
+}
+
+// Return the value to store for a CSV field of the 0-based column colIndex:
+// a date or number when it is one, as detected or as forced by ColumnTypes,
+// the text otherwise; the flag reports whether it is a date
+func typedValue(value string, colIndex int, opts Options) (interface{}, bool) {
+	// A forced type only converts the values it can parse
+	switch opts.ColumnTypes[colIndex+1] {
+	case TypeText:
+		return value, false
+	case TypeNumber:
+		if number, ok := forcedNumber(value, opts); ok {
+			return number, false
+		}
+		return value, false
+	case TypeDate:
+		layouts := opts.ColumnDateLayouts
+		if layouts == nil {
+			layouts = opts.DateLayouts
+		}
+		if date, ok := parseDate(value, layouts); ok {
+			return date, true
+		}
+		return value, false
+	}
+
+	if date, ok := parseDate(value, opts.DateLayouts); ok {
+		return date, true
+	}
+	if opts.TypeNumbers {
+		if opts.DecimalComma {
+			if number, _, ok := parseDecimalComma(value); ok {
+				return number, false
+			}
+		} else if number, ok := parseNumber(value); ok {
+			return number, false
+		}
+	}
+	return value, false
+}
+
+// Parse a number written with a decimal comma and dot thousands separators,
+// returning it with the number of decimals it was written with
+func parseDecimalComma(value string) (interface{}, int, bool) {
+	trimmed := strings.TrimSpace(value)
+	if !decimalCommaPattern.MatchString(trimmed) {
+		return nil, 0, false
+	}
+
+	decimals := 0
+	if i := strings.IndexByte(trimmed, ','); i >= 0 {
+		decimals = len(trimmed) - i - 1
+	}
+
+	// The same rules as for plain numbers apply, e.g. to leading zeros
+	normalized := strings.Replace(strings.ReplaceAll(trimmed, ".", ""), ",", ".", 1)
+	number, ok := parseNumber(normalized)
+	return number, decimals, ok
+}
+
+// Styles displaying numbers with digit grouping, by number of decimals,
+// created on first use
+type groupingStyles map[int]int
+
+// Return the grouping style of a number read with DecimalComma, or 0 when
+// it was written without separators and needs none
+func (s groupingStyles) forValue(f *excelize.File, value string) (int, error) {
+	if !strings.ContainsAny(value, ".,") {
+		return 0, nil
+	}
+	_, decimals, _ := parseDecimalComma(value)
+	if styleID, ok := s[decimals]; ok {
+		return styleID, nil
+	}
+
+	numFormat := "#,##0"
+	if decimals > 0 {
+		numFormat += "." + strings.Repeat("0", decimals)
+	}
+	styleID, err := f.NewStyle(&excelize.Style{CustomNumFmt: &numFormat})
+	if err != nil {
+		return 0, fmt.Errorf("error creating number style: %v", err)
+	}
+	s[decimals] = styleID
+	return styleID, nil
+}
+
+// Number of records read between two checks for cancellation
+const cancelCheckRows = 1000
+
+// Number of rows buffered to estimate the column widths in stream mode
+const streamWidthSampleRows = 1000
+
+// Convert CSV content read from r to a sheet through a StreamWriter, which
+// writes the rows out as they are read instead of keeping the whole sheet in
+// memory. Stream column widths must be set before the first row, so they are
+// estimated from the first streamWidthSampleRows rows.
+func streamReaderToSheet(ctx context.Context, r io.Reader, f *excelize.File, sheetName string, opts Options) error {
+	// Count the bytes read for the caller's totals
+	counter := &countingReader{r: r}
+	csvReader, err := newCSVReader(counter, sheetName, opts)
+	if err != nil {
+		return err
+	}
+	reader := withAddedHeader(withTransposed(withValidUTF8(csvReader, opts), opts), &opts)
+
+	sw, err := f.NewStreamWriter(sheetName)
+	if err != nil {
+		return fmt.Errorf("error creating stream writer: %v", err)
+	}
+
+	// Styles are referenced by ID in the streamed cells, so create them upfront
+	textStyle, err := f.NewStyle(&excelize.Style{NumFmt: 49})
+	if err != nil {
+		return fmt.Errorf("error creating text style: %v", err)
+	}
+	dateStyle := 0
+	if opts.DateLayouts != nil || slices.Contains(slices.Collect(maps.Values(opts.ColumnTypes)), TypeDate) {
+		dateFormat := opts.DateFormat
+		if dateStyle, err = f.NewStyle(&excelize.Style{CustomNumFmt: &dateFormat}); err != nil {
+			return fmt.Errorf("error creating date style: %v", err)
+		}
+	}
+
+	// Style showing the line breaks of text cells
+	wrapStyle := 0
+	if opts.WrapLines {
+		if wrapStyle, err = f.NewStyle(&excelize.Style{Alignment: &excelize.Alignment{WrapText: true}}); err != nil {
+			return fmt.Errorf("error creating wrap style: %v", err)
+		}
+	}
+
+	// Styles of the numbers read with a decimal comma
+	groupStyles := make(groupingStyles)
+
+	// Give the cells of a row the Font and FontSize, on top of their style
+	fontStyles := make(map[int]int)
+	setRow := func(rowIndex int, row []interface{}) error {
+		if opts.customFont() {
+			for colIndex, value := range row {
+				cell, ok := value.(excelize.Cell)
+				if !ok {
+					cell = excelize.Cell{Value: value}
+				}
+				styleID, err := updatedStyle(f, cell.StyleID, fontStyles, opts.applyFont)
+				if err != nil {
+					return err
+				}
+				cell.StyleID = styleID
+				row[colIndex] = cell
+			}
+		}
+		cellName, _ := excelize.CoordinatesToCellName(1, rowIndex)
+		if err := sw.SetRow(cellName, row); err != nil {
+			return fmt.Errorf("error writing row %d: %v", rowIndex, err)
+		}
+		return nil
+	}
+
+	// First two records read, telling whether the first is a header
+	var firstRecords [][]string
+
+	// Rows read while sampling the column widths, written once these are set
+	var pending [][]interface{}
+	columnWidths := make(map[int]int)
+	widthsSet := false
+	rowIndex := 1
+
+	// Set the sampled column widths, then write the buffered rows
+	flushPending := func() error {
+		// Excel expects the column definitions in ascending order
+		widths := opts.finalColumnWidths(columnWidths)
+		for _, col := range slices.Sorted(maps.Keys(widths)) {
+			if err := sw.SetColWidth(col, col, widths[col]); err != nil {
+				return fmt.Errorf("error setting column width: %v", err)
+			}
+		}
+		widthsSet = true
+
+		// Bold the header, whose cells are only written below
+		header := opts.hasHeader(sheetName, firstRecords)
+		if header {
+			headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+			if err != nil {
+				return fmt.Errorf("error creating header style: %v", err)
+			}
+			for colIndex, value := range pending[0] {
+				if cell, ok := value.(excelize.Cell); ok {
+					value = cell.Value
+				}
+				pending[0][colIndex] = excelize.Cell{StyleID: headerStyle, Value: value}
+			}
+		}
+
+		// Panes must also precede the rows
+		if colCount := usedColumnCount(columnWidths); (header || opts.FreezeCols > 0) && colCount > 0 {
+			if opts.FreezeCols > colCount {
+				return fmt.Errorf("cannot freeze %d columns: the sheet has %d", opts.FreezeCols, colCount)
+			}
+			frozenRows := 0
+			if header {
+				frozenRows = 1
+			}
+			if err := sw.SetPanes(frozenPanes(frozenRows, opts.FreezeCols)); err != nil {
+				return fmt.Errorf("error freezing panes: %v", err)
+			}
+		}
+
+		for _, row := range pending {
+			if err := setRow(rowIndex, row); err != nil {
+				return err
+			}
+			rowIndex++
+		}
+		pending = nil
+		return nil
+	}
+
+	headerSkipped := false
+	linesSkipped := 0
+	linesRead := 0
+	colCount := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error reading CSV at row %d: %v", rowIndex+len(pending), err)
+		}
+
+		// Stop promptly when the caller cancels
+		linesRead++
+		if linesRead%cancelCheckRows == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		// Discard the preamble records
+		if linesSkipped < opts.SkipLines {
+			linesSkipped++
+			continue
+		}
+
+		// Drop blank separator rows without leaving a gap
+		if opts.SkipEmpty && isEmptyRecord(record) {
+			continue
+		}
+
+		// Drop the CSV header if requested
+		if opts.SkipHeader && !headerSkipped {
+			headerSkipped = true
+			continue
+		}
+
+		// Keep only the selected columns, in their order
+		if opts.Columns != nil {
+			first := rowIndex+len(pending) == 1
+			if record, err = selectColumns(record, opts.Columns, first, first && !opts.SkipHeader); err != nil {
+				return err
+			}
+		}
+
+		// Identify the source of the row in the first column
+		if opts.SourceColumn != "" {
+			record = withSource(record, opts, rowIndex+len(pending) == 1 && !opts.SkipHeader)
+		}
+
+		// Keep the values within the cell length limit
+		record = opts.limitCellLength(record, sheetName, rowIndex+len(pending))
+
+		if len(record) > colCount {
+			colCount = len(record)
+		}
+		if len(firstRecords) < 2 {
+			firstRecords = append(firstRecords, record)
+		}
+
+		row := make([]interface{}, len(record))
+		for colIndex, value := range record {
+			// Remove quotes at the beginning and end
+			if !opts.KeepQuotes && !opts.splitsLines() {
+				value = trimQuotes(value)
+			}
+
+			// Remove the padding around the value
+			if opts.Trim {
+				value = strings.TrimSpace(value)
+			}
+
+			cellValue, isDate := typedValue(value, colIndex, opts)
+			cell := excelize.Cell{Value: cellValue}
+			if _, isText := cellValue.(string); isDate {
+				cell.StyleID = dateStyle
+			} else if isText && (leadingZeroPattern.MatchString(value) || opts.ColumnTypes[colIndex+1] == TypeText) {
+				cell.StyleID = textStyle
+			} else if opts.DecimalComma && !isText {
+				if cell.StyleID, err = groupStyles.forValue(f, value); err != nil {
+					return err
+				}
+			} else if opts.WrapLines && isText && strings.Contains(value, "\n") {
+				cell.StyleID = wrapStyle
+			}
+			row[colIndex] = cell
+
+			if !widthsSet {
+				opts.trackWidth(columnWidths, colIndex, value)
+			}
+		}
+
+		if widthsSet {
+			if err := setRow(rowIndex, row); err != nil {
+				return err
+			}
+			rowIndex++
+		} else {
+			pending = append(pending, row)
+			if len(pending) == streamWidthSampleRows {
+				if err := flushPending(); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Stop reading once the row limit is reached
+		if maxRowsReached(rowIndex-1+len(pending), opts) {
+			opts.infof("Sheet '%s': stopped after %d data rows (-max-rows)", sheetName, opts.MaxRows)
+			break
+		}
+	}
+	if !widthsSet {
+		if err := flushPending(); err != nil {
+			return err
+		}
+	}
+
+	// Refuse content without data rows, or mark the sheet as empty
+	if opts.noData(rowIndex - 1) {
+		if opts.SkipEmptyFiles {
+			return ErrNoData
+		}
+		if err := setRow(rowIndex, []interface{}{emptyMarker}); err != nil {
+			return err
+		}
+	}
+
+	if err := sw.Flush(); err != nil {
+		return fmt.Errorf("error flushing stream writer: %v", err)
+	}
+
+	opts.debugf("Sheet '%s': %d rows, %d columns", sheetName, rowIndex-1, colCount)
+
+	// Add the rows written and bytes read to the caller's totals
+	opts.addStats(rowIndex-1, colCount, counter.n)
+
+	return nil
+}
+
+// Excel's limit on the characters of a cell
+const maxExcelCellLen = 32767
+
+// Return record with the values longer than MaxCellLen characters cut to
+// end with an ellipsis or, with SplitLongCells, spilled over the next cells,
+// warning about each and counting them in Stats
+func (o Options) limitCellLength(record []string, sheetName string, row int) []string {
+	limit := o.MaxCellLen
+	if limit <= 0 || limit > maxExcelCellLen {
+		limit = maxExcelCellLen
+	}
+	tooLong := func(value string) bool {
+		return len(value) > limit && utf8.RuneCountInString(value) > limit
+	}
+	if !slices.ContainsFunc(record, tooLong) {
+		return record
+	}
+
+	limited := make([]string, 0, len(record))
+	for _, value := range record {
+		if !tooLong(value) {
+			limited = append(limited, value)
+			continue
+		}
+		if o.Stats != nil {
+			o.Stats.Long++
+		}
+
+		cellName, _ := excelize.CoordinatesToCellName(len(limited)+1, row)
+		runes := []rune(value)
+		if !o.SplitLongCells {
+			o.warnf("sheet '%s' cell %s: value of %d characters truncated to %d", sheetName, cellName, len(runes), limit)
+			limited = append(limited, string(runes[:limit-1])+"…")
+			continue
+		}
+
+		cells := 0
+		for ; len(runes) > 0; cells++ {
+			chunk := runes[:min(limit, len(runes))]
+			limited = append(limited, string(chunk))
+			runes = runes[len(chunk):]
+		}
+		o.warnf("sheet '%s' cell %s: value of %d characters split over %d cells", sheetName, cellName, utf8.RuneCountInString(value), cells)
+	}
+	return limited
+}
+
+// Remove one quote from the beginning and one from the end of a value
+func trimQuotes(value string) string {
+	value = strings.TrimPrefix(value, "\"")
+	return strings.TrimSuffix(value, "\"")
+}
+
+// Report whether the MaxRows limit is reached after writing rowsWritten
+// rows, the first of which is the header unless SkipHeader is set
+func maxRowsReached(rowsWritten int, opts Options) bool {
+	if opts.MaxRows == 0 {
+		return false
+	}
+	dataRows := rowsWritten
+	if !opts.SkipHeader {
+		dataRows--
+	}
+	return dataRows >= opts.MaxRows
+}
+
+// Return the fields of the selected columns in their order, naming them as
+// requested when the record is the header. Columns beyond the end of the
+// first record are an error, in later records their fields are left empty
+func selectColumns(record []string, columns []Column, first, header bool) ([]string, error) {
+	selected := make([]string, len(columns))
+	for i, column := range columns {
+		if column.Source > len(record) {
+			if first {
+				return nil, fmt.Errorf("column %d is out of range: the first row has %d columns", column.Source, len(record))
+			}
+			continue
+		}
+		selected[i] = record[column.Source-1]
+		if header && column.Name != "" {
+			selected[i] = column.Name
+		}
+	}
+	return selected, nil
+}
+
+// Return the record preceded by the source column: its name for the header,
+// the source for the data rows
+func withSource(record []string, opts Options, header bool) []string {
+	source := opts.SourceName
+	if header {
+		source = opts.SourceColumn
+	}
+	return append([]string{source}, record...)
+}
+
+// Report whether the first of the records written to a new sheet is a
+// header, according to the Header mode
+func (o Options) hasHeader(sheetName string, firstRecords [][]string) bool {
+	// Without the CSV header the first row holds data
+	if len(firstRecords) == 0 || (o.SkipHeader && o.AddHeader == nil) {
+		return false
+	}
+
+	switch o.Header {
+	case HeaderAlways:
+		return true
+	case HeaderAuto:
+		if o.AddHeader != nil {
+			return true
+		}
+		var second []string
+		if len(firstRecords) > 1 {
+			second = firstRecords[1]
+		}
+		header := looksLikeHeader(firstRecords[0], second)
+		o.debugf("Sheet '%s': header detected in the first row: %t", sheetName, header)
+		return header
+	}
+	return false
+}
+
+// Guess whether the first record is a header from the second one: it is
+// when every field of the first is a non-empty non-number and at least one
+// column holds a number in the second. Text-only tables, or a first data
+// row without numbers, are not recognized
+func looksLikeHeader(first, second []string) bool {
+	if len(first) == 0 || len(second) == 0 {
+		return false
+	}
+
+	isNumber := func(value string) bool {
+		return numberPattern.MatchString(value) || decimalCommaPattern.MatchString(value)
+	}
+
+	numberBelow := false
+	for i, value := range first {
+		value = strings.TrimSpace(value)
+		if value == "" || isNumber(value) {
+			return false
+		}
+		if i < len(second) && isNumber(strings.TrimSpace(second[i])) {
+			numberBelow = true
+		}
+	}
+	return numberBelow
+}
+
+// Report whether every field of a record is empty or only whitespace
+func isEmptyRecord(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Report whether the rows written from CSV content hold no data: none, or
+// only the header, which is the first row unless SkipHeader dropped it
+func (o Options) noData(rows int) bool {
+	if o.HeaderOnly {
+		return false
+	}
+	return rows == 0 || (rows == 1 && !o.SkipHeader)
+}
+
+// Return the number of used columns (the highest column index plus one)
+func usedColumnCount(columnWidths map[int]int) int {
+	count := 0
+	for colIndex := range columnWidths {
+		if colIndex+1 > count {
+			count = colIndex + 1
+		}
+	}
+	return count
+}
+
+// Fill colors alternated between consecutive groups
+var groupStripeColors = []string{"#F2F2F2", "#DDEBF7"}
+
+// Shade each run of equal values in the key column across all used
+// columns, alternating the fill color from one group to the next
+func applyGroupStripes(f *excelize.File, sheetName string, keyCol, firstRow, lastRow, colCount int) error {
+	if lastRow < firstRow || colCount == 0 {
+		return nil
+	}
+	if keyCol > colCount {
+		colCount = keyCol
+	}
+
+	groupStart, group := firstRow, 0
+	var groupKey string
+	for row := firstRow; row <= lastRow+1; row++ {
+		var key string
+		if row <= lastRow {
+			cellName, _ := excelize.CoordinatesToCellName(keyCol, row)
+			value, err := f.GetCellValue(sheetName, cellName)
+			if err != nil {
+				return fmt.Errorf("error reading cell value: %v", err)
+			}
+			key = value
+		}
+
+		// A new group starts at the first row and whenever the key changes
+		if row == firstRow {
+			groupKey = key
+			continue
+		}
+		if row <= lastRow && key == groupKey {
+			continue
+		}
+
+		// Shade the finished group
+		color := groupStripeColors[group%len(groupStripeColors)]
+		err := updateRangeStyle(f, sheetName, 1, groupStart, colCount, row-1, func(style *excelize.Style) {
+			style.Fill = excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{color}}
+		})
+		if err != nil {
+			return err
+		}
+
+		groupStart, groupKey = row, key
+		group++
+	}
+
+	return nil
+}
+
+// Style of the tables added with Table
+const tableStyle = "TableStyleMedium2"
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+// Fill color of the shaded rows with -zebra
+const zebraColor = "#F2F2F2"
+
+// Shade every second row from firstRow to lastRow across all used columns
+func applyZebraStripes(f *excelize.File, sheetName string, firstRow, lastRow, colCount int) error {
+	for row := firstRow + 1; row <= lastRow; row += 2 {
+		err := updateRangeStyle(f, sheetName, 1, row, colCount, row, func(style *excelize.Style) {
+			style.Fill = excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{zebraColor}}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Update the style of every cell in a range, keeping the rest of each
+// cell's existing style (number formats, fonts, ...)
+func updateRangeStyle(f *excelize.File, sheetName string, firstCol, firstRow, lastCol, lastRow int, update func(*excelize.Style)) error {
+	// Cells sharing a style get the same updated style
+	updated := make(map[int]int)
+
+	for row := firstRow; row <= lastRow; row++ {
+		for col := firstCol; col <= lastCol; col++ {
+			cellName, err := excelize.CoordinatesToCellName(col, row)
+			if err != nil {
+				return fmt.Errorf("error converting coordinates: %v", err)
+			}
+
+			styleID, err := f.GetCellStyle(sheetName, cellName)
+			if err != nil {
+				return fmt.Errorf("error reading cell style: %v", err)
+			}
+
+			newID, err := updatedStyle(f, styleID, updated, update)
+			if err != nil {
+				return err
+			}
+
+			if err := f.SetCellStyle(sheetName, cellName, cellName, newID); err != nil {
+				return fmt.Errorf("error setting cell style: %v", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Return the ID of the style styleID changed by update, creating it on the
+// first request and remembering it in updated
+func updatedStyle(f *excelize.File, styleID int, updated map[int]int, update func(*excelize.Style)) (int, error) {
+	if newID, ok := updated[styleID]; ok {
+		return newID, nil
+	}
+
+	style, err := f.GetStyle(styleID)
+	if err != nil {
+		return 0, fmt.Errorf("error reading cell style: %v", err)
+	}
+	// GetStyle reports "no fill" as a pattern without colors,
+	// which NewStyle would write as an empty fill
+	if len(style.Fill.Color) == 0 {
+		style.Fill = excelize.Fill{}
+	}
+	update(style)
+	newID, err := f.NewStyle(style)
+	if err != nil {
+		return 0, fmt.Errorf("error creating cell style: %v", err)
+	}
+	updated[styleID] = newID
+	return newID, nil
+}
+
+// Parse a value with the first matching date layout
+func parseDate(value string, layouts []string) (time.Time, bool) {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return time.Time{}, false
+	}
+
+	for _, layout := range layouts {
+		if date, err := time.Parse(layout, trimmed); err == nil {
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Parse a value as an integer or float; values that would lose information
+// as numbers (leading zeros, integers beyond Excel's 15-digit precision)
+// are rejected so they stay text
+func parseNumber(value string) (interface{}, bool) {
+	trimmed := strings.TrimSpace(value)
+	if !numberPattern.MatchString(trimmed) {
+		return nil, false
+	}
+
+	// Leading zeros mark identifiers such as 007 or zip codes
+	digits := strings.TrimLeft(trimmed, "+-")
+	if len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9' {
+		return nil, false
+	}
+
+	if n, err := strconv.ParseInt(trimmed, 10, 64); err == nil {
+		if len(digits) > 15 {
+			return nil, false
+		}
+		return n, true
+	}
+
+	// Integers too long for int64 are identifiers too
+	if !strings.ContainsAny(trimmed, ".eE") {
+		return nil, false
+	}
+
+	n, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil {
+		return nil, false
+	}
+	return n, true
+}
+
+// Parse a value of a TypeNumber column: unlike parseNumber, leading zeros
+// and long integers are accepted, the column being known to hold numbers
+func forcedNumber(value string, opts Options) (interface{}, bool) {
+	if opts.DecimalComma {
+		number, _, ok := parseDecimalComma(value)
+		return number, ok
+	}
+
+	trimmed := strings.TrimSpace(value)
+	if !numberPattern.MatchString(trimmed) {
+		return nil, false
+	}
+	if n, err := strconv.ParseInt(trimmed, 10, 64); err == nil {
+		return n, true
+	}
+	n, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil {
+		return nil, false
+	}
+	return n, true
+}
+
+// Parse an ISO-8601 duration and return it as a fraction of a day
+func parseISODuration(value string) (float64, bool) {
+	match := isoDurationPattern.FindStringSubmatch(value)
+	if match == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, false
+	}
+
+	// Seconds per unit for weeks, days, hours, minutes and seconds
+	unitSeconds := []float64{7 * 86400, 86400, 3600, 60, 1}
+	var seconds float64
+	for i, part := range match[1:] {
+		if part == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, false
+		}
+		seconds += n * unitSeconds[i]
+	}
+
+	return seconds / 86400, true
+}
+
+// Replace the duration strings of a column with Excel time values
+func convertDurationColumn(f *excelize.File, sheetName string, colIndex, firstRow, lastRow int) error {
+	// Elapsed-time format, so durations over 24 hours are not wrapped
+	numFmt := "[h]:mm:ss"
+	style, err := f.NewStyle(&excelize.Style{CustomNumFmt: &numFmt})
+	if err != nil {
+		return fmt.Errorf("error creating duration style: %v", err)
+	}
+
+	for row := firstRow; row <= lastRow; row++ {
+		cellName, err := excelize.CoordinatesToCellName(colIndex+1, row)
+		if err != nil {
+			return fmt.Errorf("error converting coordinates: %v", err)
+		}
+
+		value, err := f.GetCellValue(sheetName, cellName)
+		if err != nil {
+			return fmt.Errorf("error reading cell value: %v", err)
+		}
+
+		// Leave the header and empty cells as they are
+		days, ok := parseISODuration(value)
+		if !ok {
+			continue
+		}
+
+		if err := f.SetCellValue(sheetName, cellName, days); err != nil {
+			return fmt.Errorf("error setting cell value: %v", err)
+		}
+		if err := f.SetCellStyle(sheetName, cellName, cellName, style); err != nil {
+			return fmt.Errorf("error setting cell style: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Parse a percentage such as 12.5% or -3 % (12,5% with DecimalComma) into
+// its fraction, 0.125 for 12.5%; the second result reports whether it is one
+func parsePercent(value string, opts Options) (float64, bool) {
+	number, ok := strings.CutSuffix(strings.TrimSpace(value), "%")
+	if !ok {
+		return 0, false
+	}
+	parsed, ok := forcedNumber(number, opts)
+	if !ok {
+		return 0, false
+	}
+	switch n := parsed.(type) {
+	case int64:
+		return float64(n) / 100, true
+	case float64:
+		return n / 100, true
+	}
+	return 0, false
+}
+
+// Replace the percentage strings of a column with fractions in a percent format
+func convertPercentColumn(f *excelize.File, sheetName string, colIndex, firstRow, lastRow int, opts Options) error {
+	numFmt := opts.PercentFormat
+	if numFmt == "" {
+		numFmt = "0.0%"
+	}
+	style, err := f.NewStyle(&excelize.Style{CustomNumFmt: &numFmt})
+	if err != nil {
+		return fmt.Errorf("error creating percent style: %v", err)
+	}
+
+	for row := firstRow; row <= lastRow; row++ {
+		cellName, err := excelize.CoordinatesToCellName(colIndex+1, row)
+		if err != nil {
+			return fmt.Errorf("error converting coordinates: %v", err)
+		}
+
+		value, err := f.GetCellValue(sheetName, cellName)
+		if err != nil {
+			return fmt.Errorf("error reading cell value: %v", err)
+		}
+
+		// Leave the header and empty cells as they are
+		fraction, ok := parsePercent(value, opts)
+		if !ok {
+			continue
+		}
+
+		if err := f.SetCellValue(sheetName, cellName, fraction); err != nil {
+			return fmt.Errorf("error setting cell value: %v", err)
+		}
+		if err := f.SetCellStyle(sheetName, cellName, cellName, style); err != nil {
+			return fmt.Errorf("error setting cell style: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Default tokens of the boolean columns
+var (
+	defaultTrueValues  = []string{"true", "yes"}
+	defaultFalseValues = []string{"false", "no"}
+)
+
+// Parse a boolean token of the configured sets, ignoring case and the
+// surrounding spaces; the second result reports whether it is one
+func (o Options) parseBool(value string) (bool, bool) {
+	trueValues, falseValues := o.TrueValues, o.FalseValues
+	if trueValues == nil {
+		trueValues = defaultTrueValues
+	}
+	if falseValues == nil {
+		falseValues = defaultFalseValues
+	}
+
+	value = strings.TrimSpace(value)
+	equal := func(token string) bool { return strings.EqualFold(token, value) }
+	if slices.ContainsFunc(trueValues, equal) {
+		return true, true
+	}
+	if slices.ContainsFunc(falseValues, equal) {
+		return false, true
+	}
+	return false, false
+}
+
+// Convert the boolean tokens of a column to Excel booleans
+func convertBoolColumn(f *excelize.File, sheetName string, colIndex, firstRow, lastRow int, opts Options) error {
+	for row := firstRow; row <= lastRow; row++ {
+		cellName, err := excelize.CoordinatesToCellName(colIndex+1, row)
+		if err != nil {
+			return fmt.Errorf("error converting coordinates: %v", err)
+		}
+
+		value, err := f.GetCellValue(sheetName, cellName)
+		if err != nil {
+			return fmt.Errorf("error reading cell value: %v", err)
+		}
+
+		// Leave the header and empty cells as they are
+		b, ok := opts.parseBool(value)
+		if !ok {
+			continue
+		}
+
+		if err := f.SetCellBool(sheetName, cellName, b); err != nil {
+			return fmt.Errorf("error setting cell value: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Apply a bold style to the header row
+func styleHeaderRow(f *excelize.File, sheetName string, row, colCount int) error {
+	if colCount == 0 {
+		return nil
+	}
+
+	style, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return fmt.Errorf("error creating header style: %v", err)
+	}
+
+	startCell, _ := excelize.CoordinatesToCellName(1, row)
+	endCell, _ := excelize.CoordinatesToCellName(colCount, row)
+	if err := f.SetCellStyle(sheetName, startCell, endCell, style); err != nil {
+		return fmt.Errorf("error setting header style: %v", err)
+	}
+
+	return nil
+}
+
+// Write the current date/time into the timestamp cell with a bold style
+// and return the number of rows reserved above the data
+func writeUpdatedCell(f *excelize.File, sheetName string, opts Options, columnWidths map[int]int) (int, error) {
+	col, row, err := excelize.CellNameToCoordinates(opts.UpdatedCell)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp cell %s: %v", opts.UpdatedCell, err)
+	}
+
+	// Bold style with the configured date/time format
+	numFmt := opts.UpdatedFormat
+	style, err := f.NewStyle(&excelize.Style{
+		Font:         &excelize.Font{Bold: true},
+		CustomNumFmt: &numFmt,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error creating timestamp style: %v", err)
+	}
+
+	if err := f.SetCellValue(sheetName, opts.UpdatedCell, time.Now()); err != nil {
+		return 0, fmt.Errorf("error setting timestamp: %v", err)
+	}
+	if err := f.SetCellStyle(sheetName, opts.UpdatedCell, opts.UpdatedCell, style); err != nil {
+		return 0, fmt.Errorf("error setting timestamp style: %v", err)
+	}
+
+	// Make the column wide enough for the formatted timestamp
+	valueWidth := opts.textWidth(numFmt)
+	if valueWidth > columnWidths[col-1] {
+		columnWidths[col-1] = valueWidth
+	}
+
+	return row, nil
+}
+
+// Write labeled COUNT, SUM and AVERAGE formulas over a column's data range
+// into two rows starting at panelRow
+func writeSummaryPanel(f *excelize.File, sheetName string, col, panelRow, firstRow, lastRow int, columnWidths map[int]int) error {
+	// Keep the range valid even without data rows
+	if lastRow < firstRow {
+		lastRow = firstRow
+	}
+	startCell, _ := excelize.CoordinatesToCellName(col, firstRow)
+	endCell, _ := excelize.CoordinatesToCellName(col, lastRow)
+	dataRange := startCell + ":" + endCell
+
+	labelStyle, err := f.NewStyle(&excelize.Style{
+		Font: &excelize.Font{Bold: true},
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"#DDEBF7"}},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating summary style: %v", err)
+	}
+	countStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return fmt.Errorf("error creating summary style: %v", err)
+	}
+	valueStyle, err := f.NewStyle(&excelize.Style{
+		Font:   &excelize.Font{Bold: true},
+		NumFmt: 4, // #,##0.00
+	})
+	if err != nil {
+		return fmt.Errorf("error creating summary style: %v", err)
+	}
+
+	for i, function := range []string{"COUNT", "SUM", "AVERAGE"} {
+		style := valueStyle
+		if function == "COUNT" {
+			style = countStyle
+		}
+
+		labelCell, _ := excelize.CoordinatesToCellName(i+1, panelRow)
+		valueCell, _ := excelize.CoordinatesToCellName(i+1, panelRow+1)
+
+		if err := f.SetCellValue(sheetName, labelCell, function); err != nil {
+			return fmt.Errorf("error setting summary label: %v", err)
+		}
+		if err := f.SetCellFormula(sheetName, valueCell, function+"("+dataRange+")"); err != nil {
+			return fmt.Errorf("error setting summary formula: %v", err)
+		}
+		if err := f.SetCellStyle(sheetName, labelCell, labelCell, labelStyle); err != nil {
+			return fmt.Errorf("error setting summary style: %v", err)
+		}
+		if err := f.SetCellStyle(sheetName, valueCell, valueCell, style); err != nil {
+			return fmt.Errorf("error setting summary style: %v", err)
+		}
+
+		// Make the column wide enough for the label
+		labelWidth := int(float64(len(function)) * 1.2)
+		if labelWidth > columnWidths[i] {
+			columnWidths[i] = labelWidth
+		}
+	}
+
+	return nil
+}
+
+// Freeze the first rows and cols of a sheet
+func freezePanes(f *excelize.File, sheetName string, rows, cols int) error {
+	if err := f.SetPanes(sheetName, frozenPanes(rows, cols)); err != nil {
+		return fmt.Errorf("error freezing panes: %v", err)
+	}
+	return nil
+}
+
+// Return the panes freezing the first rows and cols, the scrolling pane
+// being the one below and right of them
+func frozenPanes(rows, cols int) *excelize.Panes {
+	topLeftCell, _ := excelize.CoordinatesToCellName(cols+1, rows+1)
+	activePane := "bottomRight"
+	if cols == 0 {
+		activePane = "bottomLeft"
+	} else if rows == 0 {
+		activePane = "topRight"
+	}
+	return &excelize.Panes{
+		Freeze:      true,
+		XSplit:      cols,
+		YSplit:      rows,
+		TopLeftCell: topLeftCell,
+		ActivePane:  activePane,
+	}
+}
+
+// Split a currency amount into symbol and number, reporting which
+// decimal separators the number is compatible with
+func matchCurrency(value string) (currencyMatch, bool) {
+	parts := currencyPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if parts == nil {
+		return currencyMatch{}, false
+	}
+
+	match := currencyMatch{symbol: parts[2], amount: parts[4], negative: parts[1] == "-" || parts[3] == "-"}
+	if match.symbol == "" {
+		match.symbol, match.amount, match.suffix = parts[6], parts[5], true
+	}
+	if parts[1] == "-" && parts[3] == "-" {
+		return currencyMatch{}, false
+	}
+
+	match.dotDecimal = dotDecimalPattern.MatchString(match.amount)
+	match.commaDecimal = commaDecimalPattern.MatchString(match.amount)
+	if !match.dotDecimal && !match.commaDecimal {
+		return currencyMatch{}, false
+	}
+
+	return match, true
+}
+
+// Parse the number of a currency amount using the given decimal separator
+func parseCurrencyAmount(match currencyMatch, commaDecimal bool) (float64, error) {
+	amount := match.amount
+	if commaDecimal {
+		amount = strings.ReplaceAll(amount, ".", "")
+		amount = strings.ReplaceAll(amount, ",", ".")
+	} else {
+		amount = strings.ReplaceAll(amount, ",", "")
+	}
+
+	number, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return 0, err
+	}
+	if match.negative {
+		number = -number
+	}
+	return number, nil
+}
+
+// Replace the currency strings of a column with numbers in a currency format
+func convertCurrencyColumn(f *excelize.File, sheetName string, colIndex, firstRow, lastRow int, cc *currencyColumn) error {
+	// Prefer the dot decimal separator when both readings are valid
+	commaDecimal := !cc.dotDecimal
+
+	numFmt := `"` + cc.symbol + `"#,##0.00`
+	if cc.suffix {
+		numFmt = `#,##0.00 "` + cc.symbol + `"`
+	}
+	style, err := f.NewStyle(&excelize.Style{CustomNumFmt: &numFmt})
+	if err != nil {
+		return fmt.Errorf("error creating currency style: %v", err)
+	}
+
+	for row := firstRow; row <= lastRow; row++ {
+		cellName, err := excelize.CoordinatesToCellName(colIndex+1, row)
+		if err != nil {
+			return fmt.Errorf("error converting coordinates: %v", err)
+		}
+
+		value, err := f.GetCellValue(sheetName, cellName)
+		if err != nil {
+			return fmt.Errorf("error reading cell value: %v", err)
+		}
+
+		// Leave the header and empty cells as they are
+		match, ok := matchCurrency(value)
+		if !ok {
+			continue
+		}
+		number, err := parseCurrencyAmount(match, commaDecimal)
+		if err != nil {
+			continue
+		}
+
+		if err := f.SetCellValue(sheetName, cellName, number); err != nil {
+			return fmt.Errorf("error setting cell value: %v", err)
+		}
+		if err := f.SetCellStyle(sheetName, cellName, cellName, style); err != nil {
+			return fmt.Errorf("error setting cell style: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Convert the numeric values of a column to numbers and add a color scale
+// conditional format over the column's data range
+func applyColorScale(f *excelize.File, sheetName string, opts Options, firstRow, lastRow int) error {
+	colIndex := opts.ColorScaleCol - 1
+	numericCount, err := convertNumericColumn(f, sheetName, colIndex, firstRow, lastRow, opts)
+	if err != nil {
+		return err
+	}
+
+	// Nothing to color in an empty or non-numeric column
+	if numericCount == 0 {
+		colName, _ := excelize.ColumnNumberToName(opts.ColorScaleCol)
+		opts.warnf("column %s of sheet '%s' has no numeric values, color scale skipped", colName, sheetName)
+		return nil
+	}
+
+	startCell, _ := excelize.CoordinatesToCellName(opts.ColorScaleCol, firstRow)
+	endCell, _ := excelize.CoordinatesToCellName(opts.ColorScaleCol, lastRow)
+
+	err = f.SetConditionalFormat(sheetName, startCell+":"+endCell, []excelize.ConditionalFormatOptions{colorScaleFormat(opts)})
+	if err != nil {
+		return fmt.Errorf("error setting color scale: %v", err)
+	}
+
+	return nil
+}
+
+// Return the color scale conditional format of the ColorScaleColors: from
+// the minimum to the maximum value, with the 50th percentile as midpoint
+func colorScaleFormat(opts Options) excelize.ConditionalFormatOptions {
+	format := excelize.ConditionalFormatOptions{
+		Type:     "2_color_scale",
+		Criteria: "=",
+		MinType:  "min",
+		MaxType:  "max",
+		MinColor: opts.ColorScaleColors[0],
+		MaxColor: opts.ColorScaleColors[len(opts.ColorScaleColors)-1],
+	}
+	if len(opts.ColorScaleColors) == 3 {
+		format.Type = "3_color_scale"
+		format.MidType = "percentile"
+		format.MidValue = "50"
+		format.MidColor = opts.ColorScaleColors[1]
+	}
+	return format
+}
+
+// Replace the numeric strings of a column with numbers and return how many
+// were converted; like the conversion of the values, a column forced to
+// text and identifiers with leading zeros are left as text
+func convertNumericColumn(f *excelize.File, sheetName string, colIndex, firstRow, lastRow int, opts Options) (int, error) {
+	if opts.ColumnTypes[colIndex+1] == TypeText {
+		return 0, nil
+	}
+
+	var count int
+	for row := firstRow; row <= lastRow; row++ {
+		cellName, err := excelize.CoordinatesToCellName(colIndex+1, row)
+		if err != nil {
+			return 0, fmt.Errorf("error converting coordinates: %v", err)
+		}
+
+		// Read the stored value, not the text of the number format
+		value, err := f.GetCellValue(sheetName, cellName, excelize.Options{RawCellValue: true})
+		if err != nil {
+			return 0, fmt.Errorf("error reading cell value: %v", err)
+		}
+		cellType, err := f.GetCellType(sheetName, cellName)
+		if err != nil {
+			return 0, fmt.Errorf("error reading cell type: %v", err)
+		}
+
+		// Count the values already stored as numbers, such as the
+		// converted currencies, and skip the other non-text cells
+		switch cellType {
+		case excelize.CellTypeUnset, excelize.CellTypeNumber:
+			if _, err := strconv.ParseFloat(value, 64); err == nil {
+				count++
+			}
+			continue
+		case excelize.CellTypeSharedString, excelize.CellTypeInlineString:
+		default:
+			continue
+		}
+
+		// Leave the header, empty and text cells as they are
+		trimmed := strings.TrimSpace(value)
+		if !numberPattern.MatchString(trimmed) || leadingZeroPattern.MatchString(trimmed) {
+			continue
+		}
+		number, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil {
+			continue
+		}
+
+		if err := f.SetCellValue(sheetName, cellName, number); err != nil {
+			return 0, fmt.Errorf("error setting cell value: %v", err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// Adjust column widths to fit content
+func adjustColumnWidths(f *excelize.File, sheetName string, columnWidths map[int]int, opts Options) {
+	// Adjust each column width
+	for col, width := range opts.finalColumnWidths(columnWidths) {
+		// Convert column number to column name (A, B, C, etc.)
+		colName, _ := excelize.ColumnNumberToName(col)
+
+		// Set the column width
+		f.SetColWidth(sheetName, colName, colName, width)
+	}
+}
+
+// Default limits and multiplier of the automatic column widths
+const (
+	defaultMinWidth    = 8
+	defaultMaxWidth    = 100
+	defaultWidthFactor = 1.2 // A bit of padding for better appearance
+)
+
+// Return the automatic width of a column holding text
+func (o Options) textWidth(text string) int {
+	factor := o.WidthFactor
+	if factor == 0 {
+		factor = defaultWidthFactor
+	}
+	if !o.SmartWidth {
+		return int(float64(utf8.RuneCountInString(text)) * factor)
+	}
+
+	// Wide characters, such as CJK ideographs, take about two cells
+	cells := 0
+	for _, r := range text {
+		switch width.LookupRune(r).Kind() {
+		case width.EastAsianWide, width.EastAsianFullwidth:
+			cells += 2
+		default:
+			cells++
+		}
+	}
+	return int(float64(cells) * factor)
+}
+
+// Record value in columnWidths, raising the width of its column if needed;
+// with FixedWidth the column is only marked as used, without measuring
+func (o Options) trackWidth(columnWidths map[int]int, colIndex int, value string) {
+	if o.FixedWidth > 0 {
+		if _, ok := columnWidths[colIndex]; !ok {
+			columnWidths[colIndex] = 0
+		}
+		return
+	}
+	// A value with line breaks needs the width of its longest line
+	valueWidth := 0
+	for line := range strings.SplitSeq(value, "\n") {
+		valueWidth = max(valueWidth, o.textWidth(strings.TrimSuffix(line, "\r")))
+	}
+	if valueWidth > columnWidths[colIndex] {
+		columnWidths[colIndex] = valueWidth
+	}
+}
+
+// Return the minimum and maximum automatic column widths
+func (o Options) widthLimits() (int, int) {
+	minWidth, maxWidth := o.MinWidth, o.MaxWidth
+	if minWidth == 0 {
+		minWidth = defaultMinWidth
+	}
+	if maxWidth == 0 {
+		maxWidth = defaultMaxWidth
+	}
+	return minWidth, maxWidth
+}
+
+// Return the width of each column by 1-based number: the content widths
+// within the minimum and maximum, overridden by the fixed ColWidths
+func (o Options) finalColumnWidths(columnWidths map[int]int) map[int]float64 {
+	minWidth, maxWidth := o.widthLimits()
+
+	widths := make(map[int]float64, len(columnWidths)+len(o.ColWidths))
+	for colIndex, width := range columnWidths {
+		if o.FixedWidth > 0 {
+			widths[colIndex+1] = o.FixedWidth
+			continue
+		}
+
+		// Apply minimum and maximum constraints
+		if width < minWidth {
+			width = minWidth
+		} else if width > maxWidth {
+			width = maxWidth
+		}
+		widths[colIndex+1] = float64(width)
+	}
+	for col, width := range o.ColWidths {
+		widths[col] = width
+	}
+	return widths
+}
+
+// Derive a valid sheet name from a file path (file name without extension)
+func SheetNameFromFile(path string) string {
+	return ValidSheetName(TrimCSVExt(filepath.Base(path)))
+}
+
+// ValidSheetName makes a name valid as an Excel sheet name
+func ValidSheetName(sheetName string) string {
+	// Replace invalid characters first, then make sure the sheet name is
+	// valid for Excel (max 31 characters)
+	sheetName = truncateSheetName(SanitizeSheetName(sheetName), 31)
+
+	// Cutting can leave an apostrophe at the end
+	return SanitizeSheetName(sheetName)
+}
+
+// Cut a sheet name to at most max characters, never splitting a character
+func truncateSheetName(sheetName string, max int) string {
+	if utf8.RuneCountInString(sheetName) <= max {
+		return sheetName
+	}
+	return string([]rune(sheetName)[:max])
+}
+
+// Return a sheet name not yet present in sheetNames, adding a numeric
+// suffix to duplicates, and register it. Excel compares sheet names
+// case-insensitively, so sheetNames is keyed by lower-case name.
+func UniqueSheetName(sheetName string, sheetNames map[string]bool) string {
+	originalName := sheetName
+	counter := 1
+	for sheetNames[strings.ToLower(sheetName)] {
+		// If the name already exists, add a number
+		suffix := fmt.Sprintf("_%d", counter)
+
+		// Make sure the name with the suffix doesn't exceed 31 characters
+		sheetName = suffixedSheetName(originalName, suffix)
+
+		counter++
+	}
+
+	// Register the sheet name
+	sheetNames[strings.ToLower(sheetName)] = true
+	return sheetName
+}
+
+// Append a suffix to a sheet name, cutting the name so that the result
+// does not exceed 31 characters however long the suffix grows
+func suffixedSheetName(sheetName, suffix string) string {
+	suffixLength := utf8.RuneCountInString(suffix)
+	if suffixLength >= 31 {
+		return truncateSheetName(suffix, 31)
+	}
+	return truncateSheetName(sheetName, 31-suffixLength) + suffix
+}
+
+// Sanitize the sheet name by removing invalid characters
+func SanitizeSheetName(name string) string {
+	// Characters not allowed in Excel sheet names: [ ] * ? / \ :
+	invalidChars := []string{"[", "]", "*", "?", "/", "\\", ":"}
+	result := name
+
+	for _, char := range invalidChars {
+		result = strings.ReplaceAll(result, char, "_")
+	}
+
+	// Apostrophes are allowed, but not at the start or the end
+	result = strings.Trim(result, "'")
+
+	// Make sure the name is not empty
+	if result == "" {
+		result = "Sheet"
+	}
+
+	// History is reserved by Excel, in any case
+	if strings.EqualFold(result, "History") {
+		result += "_"
+	}
+
+	return result
+}
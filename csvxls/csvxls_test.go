@@ -0,0 +1,748 @@
+package csvxls
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// Convert CSV content to a sheet named Data of a new workbook
+func convertString(t *testing.T, content string, opts Options) *excelize.File {
+	t.Helper()
+	f := excelize.NewFile()
+	t.Cleanup(func() { f.Close() })
+	if err := ConvertReader(context.Background(), strings.NewReader(content), f, "Data", opts); err != nil {
+		t.Fatalf("ConvertReader: %v", err)
+	}
+	return f
+}
+
+// Return the style of a cell of the Data sheet
+func cellStyle(t *testing.T, f *excelize.File, cell string) *excelize.Style {
+	t.Helper()
+	styleID, err := f.GetCellStyle("Data", cell)
+	if err != nil {
+		t.Fatalf("GetCellStyle(%s): %v", cell, err)
+	}
+	style, err := f.GetStyle(styleID)
+	if err != nil {
+		t.Fatalf("GetStyle(%d): %v", styleID, err)
+	}
+	return style
+}
+
+// Parse the serial number of a raw date cell
+func parseSerial(t *testing.T, raw string) float64 {
+	t.Helper()
+	serial, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		t.Fatalf("cell value %q is not a number: %v", raw, err)
+	}
+	return serial
+}
+
+func TestUpdatedCell(t *testing.T) {
+	tests := []struct {
+		name       string
+		cell       string
+		format     string
+		headerCell string // Cell receiving the first CSV row, below the timestamp
+	}{
+		{"top left", "A1", "yyyy-mm-dd hh:mm:ss", "A2"},
+		{"lower row", "C3", "dd/mm/yyyy hh:mm", "A4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.UpdatedCell = tt.cell
+			opts.UpdatedFormat = tt.format
+			f := convertString(t, "name;value\nx;1\n", opts)
+
+			raw, err := f.GetCellValue("Data", tt.cell, excelize.Options{RawCellValue: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			date, err := excelize.ExcelDateToTime(parseSerial(t, raw), false)
+			if err != nil {
+				t.Fatalf("timestamp %q is not a valid date: %v", raw, err)
+			}
+			if since := time.Since(date); since < -24*time.Hour || since > 24*time.Hour {
+				t.Errorf("timestamp %v is not the current date/time", date)
+			}
+
+			style := cellStyle(t, f, tt.cell)
+			if style.Font == nil || !style.Font.Bold {
+				t.Errorf("timestamp cell is not bold")
+			}
+			if style.CustomNumFmt == nil || *style.CustomNumFmt != tt.format {
+				t.Errorf("timestamp format = %v, want %q", style.CustomNumFmt, tt.format)
+			}
+
+			if header, _ := f.GetCellValue("Data", tt.headerCell); header != "name" {
+				t.Errorf("%s = %q, want the header pushed below the timestamp", tt.headerCell, header)
+			}
+		})
+	}
+}
+
+func TestParseISODuration(t *testing.T) {
+	tests := []struct {
+		value string
+		days  float64
+		ok    bool
+	}{
+		{"PT1H30M", 1.5 / 24, true},
+		{"PT45S", 45.0 / 86400, true},
+		{"P1DT2H", 1 + 2.0/24, true},
+		{"P1W", 7, true},
+		{"PT1.5H", 1.5 / 24, true},
+		{"P", 0, false},
+		{"PT", 0, false},
+		{"P1DT", 0, false},
+		{"1H30M", 0, false},
+		{"PT1X", 0, false},
+		{"pt1h", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			days, ok := parseISODuration(tt.value)
+			if ok != tt.ok || (ok && math.Abs(days-tt.days) > 1e-12) {
+				t.Errorf("parseISODuration(%q) = %v, %v; want %v, %v", tt.value, days, ok, tt.days, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDurationColumns(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string // Raw cell values below the header
+		time   bool     // Whether the column is converted to time values
+	}{
+		{"durations", []string{"PT1H30M", "PT45S"}, []string{"0.0625", "0.0005208333333333333"}, true},
+		{"invalid value", []string{"PT1H30M", "soon"}, []string{"PT1H30M", "soon"}, false},
+		{"text", []string{"1H30M", "45 seconds"}, []string{"1H30M", "45 seconds"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Durations = true
+			f := convertString(t, "elapsed\n"+strings.Join(tt.values, "\n")+"\n", opts)
+
+			for i, want := range tt.want {
+				cell := "A" + strconv.Itoa(i+2)
+				got, err := f.GetCellValue("Data", cell, excelize.Options{RawCellValue: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("%s = %q, want %q", cell, got, want)
+				}
+				style := cellStyle(t, f, cell)
+				isTime := style.CustomNumFmt != nil && *style.CustomNumFmt == "[h]:mm:ss"
+				if isTime != tt.time {
+					t.Errorf("%s has the time format: %v, want %v", cell, isTime, tt.time)
+				}
+			}
+		})
+	}
+}
+
+func TestColorScale(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		col       int
+		colors    []string
+		wantRange string // Empty when no color scale is expected
+		wantType  string
+	}{
+		{"three colors", "name;score\na;1\nb;5\nc;9\n", 2, []string{"#F8696B", "#FFEB84", "#63BE7B"}, "B2:B4", "3_color_scale"},
+		{"two colors", "name;score\na;1\nb;5\n", 2, []string{"#FFFFFF", "#63BE7B"}, "B2:B3", "2_color_scale"},
+		{"constant column", "score\n4\n4\n", 1, []string{"#FFFFFF", "#63BE7B"}, "A2:A3", "2_color_scale"},
+		{"text column", "name;score\na;1\nb;5\n", 1, []string{"#FFFFFF", "#63BE7B"}, "", ""},
+		{"empty column", "name;score\n", 2, []string{"#FFFFFF", "#63BE7B"}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var messages bytes.Buffer
+			opts := DefaultOptions()
+			opts.ColorScaleCol = tt.col
+			opts.ColorScaleColors = tt.colors
+			opts.Messages = &messages
+			f := convertString(t, tt.content, opts)
+
+			formats, err := f.GetConditionalFormats("Data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantRange == "" {
+				if len(formats) != 0 {
+					t.Errorf("conditional formats = %v, want none", formats)
+				}
+				if !strings.Contains(messages.String(), "color scale skipped") {
+					t.Errorf("messages = %q, want the skipped color scale reported", messages.String())
+				}
+				return
+			}
+			rules := formats[tt.wantRange]
+			if len(rules) != 1 {
+				t.Fatalf("conditional formats = %v, want one on %s", formats, tt.wantRange)
+			}
+			rule := rules[0]
+			if rule.Type != tt.wantType {
+				t.Errorf("type = %q, want %q", rule.Type, tt.wantType)
+			}
+			if !strings.EqualFold(rule.MinColor, tt.colors[0]) || !strings.EqualFold(rule.MaxColor, tt.colors[len(tt.colors)-1]) {
+				t.Errorf("colors = %s..%s, want %s..%s", rule.MinColor, rule.MaxColor, tt.colors[0], tt.colors[len(tt.colors)-1])
+			}
+		})
+	}
+}
+
+func TestColorScaleFormattedValues(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		numFormat  string
+		currency   bool
+		skipHeader bool
+		wantRange  string
+	}{
+		{"number format", "name;amount\na;1000\nb;2500.5\nc;12000\n", "#,##0.00", false, false, "B2:B4"},
+		{"currency", "name;price\na;$1,234.50\nb;$99.90\n", "", true, false, "B2:B3"},
+		{"currency with number format", "name;price\na;$1,234.50\nb;$5,000.00\n", "#,##0", true, false, "B2:B3"},
+		{"header skipped", "name;amount\na;1000\nb;2000\n", "#,##0.00", false, true, "B1:B2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var messages bytes.Buffer
+			opts := DefaultOptions()
+			opts.ColorScaleCol = 2
+			opts.NumFormat = tt.numFormat
+			opts.Currency = tt.currency
+			opts.SkipHeader = tt.skipHeader
+			opts.Messages = &messages
+			f := convertString(t, tt.content, opts)
+
+			formats, err := f.GetConditionalFormats("Data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(formats) != 1 || len(formats[tt.wantRange]) != 1 {
+				t.Errorf("conditional formats = %v, want one on %s", formats, tt.wantRange)
+			}
+			if messages.Len() != 0 {
+				t.Errorf("messages = %q, want none", messages.String())
+			}
+		})
+	}
+}
+
+func TestColorScaleKeepsText(t *testing.T) {
+	tests := []struct {
+		name  string
+		types map[int]ColumnType
+		cell  string
+		want  excelize.CellType
+	}{
+		{"leading zeros", nil, "A2", excelize.CellTypeSharedString},
+		{"number", nil, "A3", excelize.CellTypeUnset},
+		{"forced text", map[int]ColumnType{1: TypeText}, "A3", excelize.CellTypeSharedString},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.ColorScaleCol = 1
+			opts.ColumnTypes = tt.types
+			f := convertString(t, "id\n012\n100\n", opts)
+
+			got, err := f.GetCellType("Data", tt.cell)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("%s type = %v, want %v", tt.cell, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLongCellWarnings(t *testing.T) {
+	tests := []struct {
+		name  string
+		split bool
+		want  string
+	}{
+		{"truncated", false, "Warning: sheet 'Data' cell A2: value of 8 characters truncated to 5\n"},
+		{"split", true, "Warning: sheet 'Data' cell A2: value of 8 characters split over 2 cells\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var messages bytes.Buffer
+			opts := DefaultOptions()
+			opts.MaxCellLen = 5
+			opts.SplitLongCells = tt.split
+			opts.Quiet = true
+			opts.Messages = &messages
+			convertString(t, "text\nabcdefgh\n", opts)
+
+			if messages.String() != tt.want {
+				t.Errorf("messages = %q, want %q", messages.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestHeaderOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"data rows", "id;name;amount\n1;a;10\n2;b;20\n", []string{"id", "name", "amount"}},
+		{"header alone", "id;name\n", []string{"id", "name"}},
+		{"quoted header", "\"first name\";\"last;name\"\nx;y\n", []string{"first name", "last;name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.HeaderOnly = true
+			f := convertString(t, tt.content, opts)
+
+			rows, err := f.GetRows("Data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 1 {
+				t.Fatalf("rows = %q, want only the header", rows)
+			}
+			if !slices.Equal(rows[0], tt.want) {
+				t.Errorf("header = %q, want %q", rows[0], tt.want)
+			}
+			if style := cellStyle(t, f, "A1"); style.Font == nil || !style.Font.Bold {
+				t.Errorf("header is not bold")
+			}
+		})
+	}
+}
+
+func TestCurrencyColumns(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string // Raw cell values below the header
+		format string   // Number format of the converted column (empty when it stays text)
+	}{
+		{"dollar", []string{"$1,234.56", "-$99.90"}, []string{"1234.56", "-99.9"}, `"$"#,##0.00`},
+		{"euro decimal comma", []string{"€99,90", "€1.234,56"}, []string{"99.9", "1234.56"}, `"€"#,##0.00`},
+		{"euro decimal dot", []string{"€99.90", "€1,234.56"}, []string{"99.9", "1234.56"}, `"€"#,##0.00`},
+		{"euro suffix", []string{"99,90 €", "1.234,56 €"}, []string{"99.9", "1234.56"}, `#,##0.00 "€"`},
+		{"mixed symbols", []string{"$10.00", "€5.00"}, []string{"$10.00", "€5.00"}, ""},
+		{"mixed with text", []string{"$10.00", "n/a"}, []string{"$10.00", "n/a"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Currency = true
+			f := convertString(t, "price\n"+strings.Join(tt.values, "\n")+"\n", opts)
+
+			for i, want := range tt.want {
+				cell := "A" + strconv.Itoa(i+2)
+				got, err := f.GetCellValue("Data", cell, excelize.Options{RawCellValue: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("%s = %q, want %q", cell, got, want)
+				}
+				format := ""
+				if style := cellStyle(t, f, cell); style.CustomNumFmt != nil {
+					format = *style.CustomNumFmt
+				}
+				if format != tt.format {
+					t.Errorf("%s format = %q, want %q", cell, format, tt.format)
+				}
+			}
+		})
+	}
+}
+
+func TestSummaryPanel(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		col       int
+		wantRange string
+	}{
+		{"first column", "amount\n1\n2\n3\n", 1, "A3:A6"},
+		{"second column", "name;amount\na;10\nb;20\n", 2, "B3:B5"},
+		{"no data rows", "name;amount\n", 2, "B3:B3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.SummaryCol = tt.col
+			f := convertString(t, tt.content, opts)
+
+			for i, function := range []string{"COUNT", "SUM", "AVERAGE"} {
+				labelCell, _ := excelize.CoordinatesToCellName(i+1, 1)
+				valueCell, _ := excelize.CoordinatesToCellName(i+1, 2)
+				if label, _ := f.GetCellValue("Data", labelCell); label != function {
+					t.Errorf("%s = %q, want %q", labelCell, label, function)
+				}
+				formula, err := f.GetCellFormula("Data", valueCell)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := function + "(" + tt.wantRange + ")"; formula != want {
+					t.Errorf("%s formula = %q, want %q", valueCell, formula, want)
+				}
+				if style := cellStyle(t, f, valueCell); style.Font == nil || !style.Font.Bold {
+					t.Errorf("%s is not bold", valueCell)
+				}
+			}
+
+			panes, err := f.GetPanes("Data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !panes.Freeze || panes.YSplit < 2 {
+				t.Errorf("panes = %+v, want the summary rows frozen", panes)
+			}
+		})
+	}
+}
+
+func TestSummaryPanelColumnOutOfRange(t *testing.T) {
+	opts := DefaultOptions()
+	opts.SummaryCol = 3
+	f := excelize.NewFile()
+	defer f.Close()
+	err := ConvertReader(context.Background(), strings.NewReader("name;amount\na;10\n"), f, "Data", opts)
+	if err == nil || !strings.Contains(err.Error(), "the sheet has 2") {
+		t.Errorf("ConvertReader() error = %v, want the column out of range", err)
+	}
+}
+
+func TestUTF16Input(t *testing.T) {
+	content := "city;name\r\nZürich;Grüezi\r\n東京;こんにちは\r\n"
+	tests := []struct {
+		name       string
+		endianness unicode.Endianness
+		encoding   string // Encoding reported by decodeBOM
+	}{
+		{"little endian", unicode.LittleEndian, "UTF-16LE"},
+		{"big endian", unicode.BigEndian, "UTF-16BE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Windows "Unicode" export: UTF-16 starting with a BOM
+			encoded, err := unicode.UTF16(tt.endianness, unicode.UseBOM).NewEncoder().String(content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, encoding, err := decodeBOM(strings.NewReader(encoded)); err != nil || encoding != tt.encoding {
+				t.Errorf("decodeBOM encoding = %q, %v; want %s", encoding, err, tt.encoding)
+			}
+			f := convertString(t, encoded, DefaultOptions())
+
+			rows, err := f.GetRows("Data")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := [][]string{{"city", "name"}, {"Zürich", "Grüezi"}, {"東京", "こんにちは"}}
+			if !slices.EqualFunc(rows, want, slices.Equal) {
+				t.Errorf("rows = %q, want %q", rows, want)
+			}
+		})
+	}
+}
+
+func TestGroupStripes(t *testing.T) {
+	tests := []struct {
+		name   string
+		keyCol int
+		keys   []string
+		want   []string // Fill of each data row
+	}{
+		{"alternating groups", 1, []string{"a", "a", "b", "c", "c", "c"}, []string{"F2F2F2", "F2F2F2", "DDEBF7", "F2F2F2", "F2F2F2", "F2F2F2"}},
+		{"single group", 1, []string{"a", "a"}, []string{"F2F2F2", "F2F2F2"}},
+		{"key in second column", 2, []string{"x", "y", "y", "x"}, []string{"F2F2F2", "DDEBF7", "DDEBF7", "F2F2F2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var content strings.Builder
+			content.WriteString("k1;k2;value\n")
+			for i, key := range tt.keys {
+				fmt.Fprintf(&content, "%s;%s;v%d\n", key, key, i)
+			}
+			opts := DefaultOptions()
+			opts.GroupStripeCol = tt.keyCol
+			f := convertString(t, content.String(), opts)
+
+			fill := func(cell string) string {
+				style := cellStyle(t, f, cell)
+				if len(style.Fill.Color) == 0 {
+					return ""
+				}
+				return strings.ToUpper(strings.TrimPrefix(style.Fill.Color[0], "#"))
+			}
+			if got := fill("A1"); got != "" {
+				t.Errorf("header fill = %q, want none", got)
+			}
+			for i, want := range tt.want {
+				row := strconv.Itoa(i + 2)
+				for _, col := range []string{"A", "B", "C"} {
+					if got := fill(col + row); got != want {
+						t.Errorf("%s%s fill = %q, want %q", col, row, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestKeepQuotes(t *testing.T) {
+	const content = "quote;plain\n\"He said \"\"hi\"\"\";\"x\"\n"
+	tests := []struct {
+		name       string
+		keepQuotes bool
+		stream     bool
+		want       string
+	}{
+		{"trimmed", false, false, `He said "hi`},
+		{"kept", true, false, `He said "hi"`},
+		{"trimmed streaming", false, true, `He said "hi`},
+		{"kept streaming", true, true, `He said "hi"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.KeepQuotes = tt.keepQuotes
+			opts.Stream = tt.stream
+			f := convertString(t, content, opts)
+			if got, _ := f.GetCellValue("Data", "A2"); got != tt.want {
+				t.Errorf("A2 = %q, want %q", got, tt.want)
+			}
+			if got, _ := f.GetCellValue("Data", "B2"); got != "x" {
+				t.Errorf("B2 = %q, want %q", got, "x")
+			}
+		})
+	}
+}
+
+func TestSanitizeSheetName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"valid name", "Sales 2024", "Sales 2024"},
+		{"invalid characters", `a[b]c*d?e/f\g:h`, "a_b_c_d_e_f_g_h"},
+		{"inner apostrophe", "Bob's", "Bob's"},
+		{"leading and trailing apostrophes", "''Data'", "Data"},
+		{"empty", "", "Sheet"},
+		{"only apostrophes", "'''", "Sheet"},
+		{"history", "History", "History_"},
+		{"history in another case", "hIsToRy", "hIsToRy_"},
+		{"history with apostrophes", "'History'", "History_"},
+		{"history prefix", "Historyx", "Historyx"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SanitizeSheetName(tt.in); got != tt.want {
+				t.Errorf("SanitizeSheetName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidSheetName(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"short", "Data", "Data"},
+		{"exactly 31 characters", strings.Repeat("a", 31), strings.Repeat("a", 31)},
+		{"too long", strings.Repeat("a", 40), strings.Repeat("a", 31)},
+		{"cut after sanitizing", strings.Repeat("/", 40), strings.Repeat("_", 31)},
+		{"apostrophe at the cut", strings.Repeat("a", 30) + "'b", strings.Repeat("a", 30)},
+		{"multibyte characters", strings.Repeat("é", 40), strings.Repeat("é", 31)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidSheetName(tt.in); got != tt.want {
+				t.Errorf("ValidSheetName(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUniqueSheetName(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  []string
+	}{
+		{"distinct", []string{"a", "b"}, []string{"a", "b"}},
+		{"duplicates", []string{"Data", "Data", "Data"}, []string{"Data", "Data_1", "Data_2"}},
+		{"case-insensitive", []string{"Data", "data", "DATA"}, []string{"Data", "data_1", "DATA_2"}},
+		{"suffix taken", []string{"Data_1", "Data", "Data"}, []string{"Data_1", "Data", "Data_2"}},
+		{"suffix taken in another case", []string{"DATA_1", "Data", "Data"}, []string{"DATA_1", "Data", "Data_2"}},
+		{
+			"long name",
+			[]string{strings.Repeat("a", 31), strings.Repeat("a", 31)},
+			[]string{strings.Repeat("a", 31), strings.Repeat("a", 29) + "_1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sheetNames := map[string]bool{}
+			var got []string
+			for _, name := range tt.names {
+				got = append(got, UniqueSheetName(name, sheetNames))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("UniqueSheetName = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUniqueSheetNameManyDuplicates(t *testing.T) {
+	for _, base := range []string{"Data", strings.Repeat("x", 31), strings.Repeat("é", 31)} {
+		sheetNames := map[string]bool{}
+		seen := map[string]bool{}
+		for i := range 150 {
+			// Alternate the case, which Excel ignores
+			name := base
+			if i%2 == 1 {
+				name = strings.ToUpper(base)
+			}
+			got := UniqueSheetName(name, sheetNames)
+			if n := len([]rune(got)); n > 31 {
+				t.Fatalf("name %d of %q has %d characters: %q", i, base, n, got)
+			}
+			if seen[strings.ToLower(got)] {
+				t.Fatalf("name %d of %q is a duplicate: %q", i, base, got)
+			}
+			seen[strings.ToLower(got)] = true
+		}
+	}
+}
+
+// Build a CSV of rows data rows of ten mixed text, number and date columns
+func syntheticCSV(rows int) string {
+	var content strings.Builder
+	content.WriteString("id;name;city;amount;price;qty;date;code;flag;note\n")
+	for i := range rows {
+		fmt.Fprintf(&content, "%d;name %d;city %d;%d.%02d;%d,%02d;%d;2024-%02d-%02d;C%05d;%t;note %d\n",
+			i, i, i%100, i*3, i%100, i%1000, i%100, i%50, i%12+1, i%28+1, i, i%2 == 0, i)
+	}
+	return content.String()
+}
+
+// Compare the in-memory path, which writes cells one by one, with Stream
+func BenchmarkConvertRecords(b *testing.B) {
+	content := syntheticCSV(100000)
+	for _, stream := range []bool{false, true} {
+		name := "memory"
+		if stream {
+			name = "stream"
+		}
+		b.Run(name, func(b *testing.B) {
+			opts := DefaultOptions()
+			opts.Stream = stream
+			for b.Loop() {
+				f := excelize.NewFile()
+				if err := ConvertReader(context.Background(), strings.NewReader(content), f, "Data", opts); err != nil {
+					b.Fatalf("ConvertReader: %v", err)
+				}
+				f.Close()
+			}
+		})
+	}
+}
+
+// Compare SetCellValue with SetSheetRow, which the in-memory path does not
+// use because it only loops over SetCellValue through reflection
+func BenchmarkSetCells(b *testing.B) {
+	const rows, cols = 20000, 10
+	row := make([]interface{}, cols)
+	for i := range row {
+		row[i] = fmt.Sprintf("value %d", i)
+	}
+	b.Run("SetCellValue", func(b *testing.B) {
+		for b.Loop() {
+			f := excelize.NewFile()
+			for r := 1; r <= rows; r++ {
+				for c, value := range row {
+					cell, _ := excelize.CoordinatesToCellName(c+1, r)
+					if err := f.SetCellValue("Sheet1", cell, value); err != nil {
+						b.Fatalf("SetCellValue: %v", err)
+					}
+				}
+			}
+			f.Close()
+		}
+	})
+	b.Run("SetSheetRow", func(b *testing.B) {
+		for b.Loop() {
+			f := excelize.NewFile()
+			for r := 1; r <= rows; r++ {
+				cell, _ := excelize.CoordinatesToCellName(1, r)
+				if err := f.SetSheetRow("Sheet1", cell, &row); err != nil {
+					b.Fatalf("SetSheetRow: %v", err)
+				}
+			}
+			f.Close()
+		}
+	})
+}
+
+func TestMultilineWidth(t *testing.T) {
+	const longest = "the longest line of the note"
+	content := "id;note\n1;\"short\n" + longest + "\r\nend\"\n"
+	tests := []struct {
+		name      string
+		stream    bool
+		wrapLines bool
+	}{
+		{"memory", false, false},
+		{"memory wrapped", false, true},
+		{"stream", true, false},
+		{"stream wrapped", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Stream = tt.stream
+			opts.WrapLines = tt.wrapLines
+			f := convertString(t, content, opts)
+
+			if got, _ := f.GetCellValue("Data", "B2"); strings.Count(got, "\n") != 2 || !strings.Contains(got, longest) {
+				t.Errorf("B2 = %q, want the three lines", got)
+			}
+			want := float64(opts.textWidth(longest))
+			if got, _ := f.GetColWidth("Data", "B"); got != want {
+				t.Errorf("column B width = %v, want %v", got, want)
+			}
+			if got := cellStyle(t, f, "B2").Alignment; (got != nil && got.WrapText) != tt.wrapLines {
+				t.Errorf("B2 wrap text = %v, want %v", got != nil && got.WrapText, tt.wrapLines)
+			}
+		})
+	}
+}
@@ -2,7 +2,10 @@ module csvtoxls
 
 go 1.24.2
 
-require github.com/xuri/excelize/v2 v2.9.0
+require (
+	github.com/xuri/excelize/v2 v2.9.0
+	golang.org/x/text v0.19.0
+)
 
 require (
 	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826 // indirect
@@ -12,5 +15,4 @@ require (
 	github.com/xuri/nfp v0.0.0-20240318013403-ab9948c2c4a7 // indirect
 	golang.org/x/crypto v0.28.0 // indirect
 	golang.org/x/net v0.30.0 // indirect
-	golang.org/x/text v0.19.0 // indirect
 )